@@ -7,34 +7,96 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/conductorone/baton-sdk/pkg/config"
 	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
 	"github.com/conductorone/baton-sdk/pkg/field"
+	"github.com/conductorone/baton-sdk/pkg/metrics"
 	"github.com/conductorone/baton-sdk/pkg/types"
 	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
 	"github.com/spf13/viper"
 	connectorSchema "github.com/spiros-spiros/baton-keycloak/pkg/connector"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+	"go.opentelemetry.io/otel"
 	"go.uber.org/zap"
 )
 
 var (
 	apiUrlField               = field.StringField("api_url", field.WithDescription("The URL of the Keycloak server"), field.WithRequired(true))
 	realmField                = field.StringField("realm", field.WithDescription("The realm to connect to"), field.WithRequired(true))
-	keycloakclientField       = field.StringField("keycloak_client_id", field.WithDescription("The client ID to use for authentication"), field.WithRequired(true))
-	keycloakclientSecretField = field.StringField("keycloak_client_secret", field.WithDescription("The client secret to use for authentication"), field.WithRequired(true))
+	authRealmField            = field.StringField("auth_realm", field.WithDescription("The realm to log in to, when it differs from the synced realm (e.g. master)"))
+	authMethodField           = field.SelectField("auth_method", keycloak.AuthMethods, field.WithDescription("How to log in to Keycloak: client_secret, signed_jwt, mtls or admin_password"), field.WithDefaultValue(string(keycloak.AuthClientSecret)))
+	keycloakclientField       = field.StringField("keycloak_client_id", field.WithDescription("The client ID to use for authentication"))
+	keycloakclientSecretField = field.StringField("keycloak_client_secret", field.WithDescription("The client secret to use for authentication"))
+	privateKeyPathField       = field.StringField("keycloak_private_key_path", field.WithDescription("PEM private key signing the client assertion, for signed_jwt"))
+	privateKeyIDField         = field.StringField("keycloak_private_key_id", field.WithDescription("Key ID of the private key in the client's JWKS, for signed_jwt"))
+	signingAlgorithmField     = field.StringField("keycloak_signing_algorithm", field.WithDescription("Algorithm signing the client assertion, for signed_jwt"), field.WithDefaultValue("RS256"))
+	clientCertPathField       = field.StringField("keycloak_client_cert_path", field.WithDescription("PEM client certificate presented to Keycloak, required for mtls"))
+	clientKeyPathField        = field.StringField("keycloak_client_key_path", field.WithDescription("PEM key of the client certificate, required for mtls"))
+	adminUsernameField        = field.StringField("keycloak_admin_username", field.WithDescription("Admin user logging in through admin-cli, for admin_password"))
+	adminPasswordField        = field.StringField("keycloak_admin_password", field.WithDescription("Password of the admin user, for admin_password"))
+	caCertPathField           = field.StringField("keycloak_ca_cert_path", field.WithDescription("PEM bundle of CAs to trust for Keycloak in addition to the system ones"))
+	minTLSVersionField        = field.SelectField("keycloak_min_tls_version", []string{"1.2", "1.3"}, field.WithDescription("Minimum TLS version towards Keycloak: 1.2 or 1.3"), field.WithDefaultValue("1.2"))
+	insecureSkipVerifyField   = field.BoolField("keycloak_insecure_skip_verify", field.WithDescription("Skip verifying Keycloak's certificate. Dev clusters only"))
+	proxyURLField             = field.StringField("keycloak_proxy_url", field.WithDescription("HTTP proxy to reach Keycloak through, defaults to HTTPS_PROXY"))
 	batonClientIDField        = field.StringField("baton_client_id", field.WithDescription("The Baton client ID"), field.WithRequired(true))
 	batonClientSecretField    = field.StringField("baton_client_secret", field.WithDescription("The Baton client secret"), field.WithRequired(true))
+	incrementalSyncField      = field.BoolField("incremental_sync", field.WithDescription("Only refetch users and groups changed since the last sync, based on admin events"))
+	maxRequestsPerSecondField = field.IntField("max_requests_per_second", field.WithDescription("Requests per second the connector sends to Keycloak at most, 0 for no limit"))
+	maxInFlightRequestsField  = field.IntField("max_in_flight_requests", field.WithDescription("Requests the connector has open against Keycloak at once at most, 0 for no limit"))
+	dryRunField               = field.BoolField("dry_run", field.WithDescription("Log the changes provisioning would make to Keycloak instead of making them"))
+	protectedGroupsField      = field.StringSliceField("protected_groups", field.WithDescription("Paths of groups provisioning must never change, subgroups included, or regex:<pattern> matching paths"))
+	protectedRolesField       = field.StringSliceField("protected_roles", field.WithDescription("Realm roles (name) or client roles (clientId/name) no group granting them may be provisioned, or regex:<pattern> matching those names"))
+	hideProtectedField        = field.BoolField("hide_protected_entitlements", field.WithDescription("Mark the entitlements of protected groups and roles as not requestable"))
+	groupPathPrefixesField    = field.StringSliceField("group_path_prefixes", field.WithDescription("Only sync the top-level groups with these paths, e.g. /workforce, and their subgroups"))
+	userSearchField           = field.StringField("user_search", field.WithDescription("Only sync the users Keycloak's search finds for this, over username, email, first and last name: a prefix by default, * as a wildcard, a quoted term exactly"))
+	userAttributesField       = field.StringSliceField("user_attributes", field.WithDescription("Only sync the users having all these key=value attributes"))
+	usersInGroupsOnlyField    = field.BoolField("users_in_groups_only", field.WithDescription("Only sync the users that are members of a synced group or one of its subgroups"))
+	clientIDsField            = field.StringSliceField("client_ids", field.WithDescription("Only sync the clients with these clientIds, along with their roles"))
+	groupDisplayNameField     = field.SelectField("group_display_name", []string{connectorSchema.GroupDisplayNameName, connectorSchema.GroupDisplayNamePath}, field.WithDescription("Display groups by name or by full path, telling apart groups of the same name under different parents"), field.WithDefaultValue(connectorSchema.GroupDisplayNameName))
+	grantDurationField        = field.StringField("grant_duration", field.WithDescription("How long group memberships granted by the connector last, e.g. 8h. Groups override it with the baton_grant_duration attribute. Empty keeps them until revoked"))
 )
 
-var configuration = field.NewConfiguration([]field.SchemaField{
-	apiUrlField,
-	realmField,
-	keycloakclientField,
-	keycloakclientSecretField,
-	batonClientIDField,
-	batonClientSecretField,
-})
+var configuration = field.NewConfiguration(
+	[]field.SchemaField{
+		apiUrlField,
+		realmField,
+		authRealmField,
+		authMethodField,
+		keycloakclientField,
+		keycloakclientSecretField,
+		privateKeyPathField,
+		privateKeyIDField,
+		signingAlgorithmField,
+		clientCertPathField,
+		clientKeyPathField,
+		adminUsernameField,
+		adminPasswordField,
+		caCertPathField,
+		minTLSVersionField,
+		insecureSkipVerifyField,
+		proxyURLField,
+		batonClientIDField,
+		batonClientSecretField,
+		incrementalSyncField,
+		maxRequestsPerSecondField,
+		maxInFlightRequestsField,
+		grantDurationField,
+		dryRunField,
+		protectedGroupsField,
+		protectedRolesField,
+		hideProtectedField,
+		groupPathPrefixesField,
+		userSearchField,
+		userAttributesField,
+		usersInGroupsOnlyField,
+		clientIDsField,
+		groupDisplayNameField,
+	},
+	field.FieldsRequiredTogether(clientCertPathField, clientKeyPathField),
+	field.FieldsRequiredTogether(adminUsernameField, adminPasswordField),
+)
 
 var version = "dev"
 
@@ -67,17 +129,63 @@ func getConnector(ctx context.Context, v *viper.Viper) (types.ConnectorServer, e
 		return nil, err
 	}
 
-	keycloakServerURL := v.GetString(apiUrlField.FieldName)
-	keycloakRealm := v.GetString(realmField.FieldName)
-	keycloakClientID := v.GetString(keycloakclientField.FieldName)
-	keycloakClientSecret := v.GetString(keycloakclientSecretField.FieldName)
+	var grantDuration time.Duration
+	if raw := v.GetString(grantDurationField.FieldName); raw != "" {
+		var err error
+		grantDuration, err = time.ParseDuration(raw)
+		if err != nil || grantDuration < 0 {
+			return nil, fmt.Errorf("invalid grant_duration %q", raw)
+		}
+	}
+
+	metricsHandler := metrics.NewOtelHandler(ctx, otel.GetMeterProvider(), "baton-keycloak")
+
+	cb, err := connectorSchema.New(ctx, connectorSchema.Config{
+		Config: keycloak.Config{
+			ServerURL:        v.GetString(apiUrlField.FieldName),
+			Realm:            v.GetString(realmField.FieldName),
+			AuthRealm:        v.GetString(authRealmField.FieldName),
+			AuthMethod:       keycloak.AuthMethod(v.GetString(authMethodField.FieldName)),
+			ClientID:         v.GetString(keycloakclientField.FieldName),
+			ClientSecret:     v.GetString(keycloakclientSecretField.FieldName),
+			PrivateKeyPath:   v.GetString(privateKeyPathField.FieldName),
+			PrivateKeyID:     v.GetString(privateKeyIDField.FieldName),
+			SigningAlgorithm: v.GetString(signingAlgorithmField.FieldName),
+			ClientCertPath:   v.GetString(clientCertPathField.FieldName),
+			ClientKeyPath:    v.GetString(clientKeyPathField.FieldName),
+			AdminUsername:    v.GetString(adminUsernameField.FieldName),
+			AdminPassword:    v.GetString(adminPasswordField.FieldName),
+
+			CACertPath:         v.GetString(caCertPathField.FieldName),
+			MinTLSVersion:      v.GetString(minTLSVersionField.FieldName),
+			InsecureSkipVerify: v.GetBool(insecureSkipVerifyField.FieldName),
+			ProxyURL:           v.GetString(proxyURLField.FieldName),
+
+			MaxRequestsPerSecond: float64(v.GetInt(maxRequestsPerSecondField.FieldName)),
+			MaxInFlightRequests:  v.GetInt(maxInFlightRequestsField.FieldName),
+		},
+		IncrementalSync: v.GetBool(incrementalSyncField.FieldName),
+		GrantDuration:   grantDuration,
+		DryRun:          v.GetBool(dryRunField.FieldName),
+
+		ProtectedGroups:           v.GetStringSlice(protectedGroupsField.FieldName),
+		ProtectedRoles:            v.GetStringSlice(protectedRolesField.FieldName),
+		HideProtectedEntitlements: v.GetBool(hideProtectedField.FieldName),
+
+		GroupPathPrefixes: v.GetStringSlice(groupPathPrefixesField.FieldName),
+		UserSearch:        v.GetString(userSearchField.FieldName),
+		UserAttributes:    v.GetStringSlice(userAttributesField.FieldName),
+		UsersInGroupsOnly: v.GetBool(usersInGroupsOnlyField.FieldName),
+		ClientIDs:         v.GetStringSlice(clientIDsField.FieldName),
+		GroupDisplayName:  v.GetString(groupDisplayNameField.FieldName),
 
-	cb, err := connectorSchema.New(ctx, keycloakServerURL, keycloakRealm, keycloakClientID, keycloakClientSecret)
+		Metrics: metricsHandler,
+	})
 	if err != nil {
 		l.Error("error creating connector", zap.Error(err))
 		return nil, err
 	}
-	connector, err := connectorbuilder.NewConnector(ctx, cb)
+	connector, err := connectorbuilder.NewConnector(ctx, cb, connectorbuilder.WithMetricsHandler(metricsHandler))
 	if err != nil {
 		l.Error("error creating connector", zap.Error(err))
 		return nil, err
@@ -0,0 +1,129 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+
+	config "github.com/conductorone/baton-sdk/pb/c1/config/v1"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/actions"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	enableUserAction             = "enable_user"
+	disableUserAction            = "disable_user"
+	clearBruteForceLockoutAction = "clear_brute_force_lockout"
+
+	userIDArgument = "user_id"
+)
+
+// userIDField is the single argument shared by every user action.
+var userIDField = &config.Field{
+	Name:        userIDArgument,
+	DisplayName: "User ID",
+	Description: "The Keycloak ID of the user to act on.",
+	IsRequired:  true,
+	Field:       &config.Field_StringField{StringField: &config.StringField{}},
+}
+
+var successField = &config.Field{
+	Name:        "success",
+	DisplayName: "Success",
+	Field:       &config.Field_BoolField{BoolField: &config.BoolField{}},
+}
+
+// RegisterActionManager exposes the custom actions on-call can run against a user
+// without going through a grant or revoke, e.g. to suspend a compromised account.
+func (c *Connector) RegisterActionManager(ctx context.Context) (connectorbuilder.CustomActionManager, error) {
+	actionManager := actions.NewActionManager(ctx)
+
+	userActions := []struct {
+		schema  *v2.BatonActionSchema
+		handler actions.ActionHandler
+	}{
+		{
+			schema: &v2.BatonActionSchema{
+				Name:        enableUserAction,
+				DisplayName: "Enable user",
+				Description: "Enable a disabled Keycloak user so they can log in again.",
+				Arguments:   []*config.Field{userIDField},
+				ReturnTypes: []*config.Field{successField},
+			},
+			handler: c.userAction(enableUserAction, c.client.EnableUser),
+		},
+		{
+			schema: &v2.BatonActionSchema{
+				Name:        disableUserAction,
+				DisplayName: "Disable user",
+				Description: "Disable a Keycloak user, preventing any further logins.",
+				Arguments:   []*config.Field{userIDField},
+				ReturnTypes: []*config.Field{successField},
+			},
+			handler: c.userAction(disableUserAction, c.client.DisableUser),
+		},
+		{
+			schema: &v2.BatonActionSchema{
+				Name:        clearBruteForceLockoutAction,
+				DisplayName: "Clear brute force lockout",
+				Description: "Remove a brute force detection lockout from a Keycloak user.",
+				Arguments:   []*config.Field{userIDField},
+				ReturnTypes: []*config.Field{successField},
+			},
+			handler: c.userAction(clearBruteForceLockoutAction, c.client.ClearUserBruteForceLockout),
+		},
+	}
+
+	for _, action := range userActions {
+		if err := actionManager.RegisterAction(ctx, action.schema.Name, action.schema, action.handler); err != nil {
+			return nil, err
+		}
+	}
+
+	return actionManager, nil
+}
+
+// userAction wraps a Keycloak call taking a user ID into an action handler.
+func (c *Connector) userAction(name string, call func(ctx context.Context, userID string) error) actions.ActionHandler {
+	return func(ctx context.Context, args *structpb.Struct) (*structpb.Struct, annotations.Annotations, error) {
+		l := ctxzap.Extract(ctx)
+
+		userID, err := userIDFromArgs(args)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		l.Info("Running user action",
+			zap.String("action", name),
+			zap.String("user_id", userID),
+		)
+
+		if err := call(ctx, userID); err != nil {
+			l.Error("User action failed", zap.String("action", name), zap.Error(err))
+			return nil, nil, fmt.Errorf("%s failed for user %s: %w", name, userID, err)
+		}
+
+		return &structpb.Struct{
+			Fields: map[string]*structpb.Value{
+				"success": structpb.NewBoolValue(true),
+			},
+		}, nil, nil
+	}
+}
+
+func userIDFromArgs(args *structpb.Struct) (string, error) {
+	if args == nil {
+		return "", fmt.Errorf("missing %s argument", userIDArgument)
+	}
+
+	value, ok := args.GetFields()[userIDArgument]
+	if !ok || value.GetStringValue() == "" {
+		return "", fmt.Errorf("missing %s argument", userIDArgument)
+	}
+
+	return value.GetStringValue(), nil
+}
@@ -0,0 +1,97 @@
+package connector
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v13"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak/fake"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestEnableAndDisableUserActions(t *testing.T) {
+	ctx := context.Background()
+	kc := fake.New(testRealm)
+	alice := kc.AddUser(gocloak.User{
+		Username:   gocloak.StringP("alice"),
+		Enabled:    gocloak.BoolP(false),
+		Attributes: &map[string][]string{"department": {"sre"}},
+	})
+
+	srv := fake.NewServer(kc, e2eClientID, e2eClientSecret)
+	defer srv.Close()
+	client, err := keycloak.NewClient(keycloak.Config{
+		ServerURL:    srv.URL,
+		Realm:        testRealm,
+		ClientID:     e2eClientID,
+		ClientSecret: e2eClientSecret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newConnector(client, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	actionManager, err := c.RegisterActionManager(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		action  string
+		enabled bool
+	}{
+		{enableUserAction, true},
+		{disableUserAction, false},
+	} {
+		args := &structpb.Struct{Fields: map[string]*structpb.Value{
+			userIDArgument: structpb.NewStringValue(alice),
+		}}
+		_, status, _, _, err := actionManager.InvokeAction(ctx, tc.action, args)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.action, err)
+		}
+		if status != v2.BatonActionStatus_BATON_ACTION_STATUS_COMPLETE {
+			t.Fatalf("%s ended with status %v", tc.action, status)
+		}
+
+		user, err := kc.GetUser(ctx, alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := user.Enabled != nil && *user.Enabled; got != tc.enabled {
+			t.Errorf("after %s, enabled is %v", tc.action, got)
+		}
+		// The user profile clears attributes left out of an update.
+		if user.Attributes == nil || !slices.Equal((*user.Attributes)["department"], []string{"sre"}) {
+			t.Errorf("after %s, attributes are %v, want the department kept", tc.action, user.Attributes)
+		}
+	}
+}
+
+func TestUserActionErrors(t *testing.T) {
+	ctx := context.Background()
+	actionManager, err := newTestConnector(fake.New(testRealm)).RegisterActionManager(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, args := range map[string]*structpb.Struct{
+		"missing user ID": {},
+		"unknown user": {Fields: map[string]*structpb.Value{
+			userIDArgument: structpb.NewStringValue("missing"),
+		}},
+	} {
+		_, status, _, _, err := actionManager.InvokeAction(ctx, disableUserAction, args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != v2.BatonActionStatus_BATON_ACTION_STATUS_FAILED {
+			t.Errorf("%s: disable_user ended with status %v", name, status)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/Nerzal/gocloak/v13"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+)
+
+// Fine-grained admin permissions let delegated admins manage single groups or
+// clients. Keycloak stores them as permissions of the realm-management client,
+// one per scope (manage, view, manage-members, ...), each pointing at policies
+// that name the users, groups and roles allowed to use the scope.
+//
+// Only user, group and role policies with positive logic are resolved. Anything
+// else (time, client, JavaScript policies, negative logic) is ignored, so the
+// grants describe who a permission is handed to rather than the full decision.
+
+// managementScopes returns the scopes of the permissions that are enabled, in a
+// stable order.
+func managementScopes(permissions *gocloak.ManagementPermissionRepresentation) []string {
+	if permissions == nil || permissions.Enabled == nil || !*permissions.Enabled || permissions.ScopePermissions == nil {
+		return nil
+	}
+
+	scopes := make([]string, 0, len(*permissions.ScopePermissions))
+	for scope := range *permissions.ScopePermissions {
+		scopes = append(scopes, scope)
+	}
+	slices.Sort(scopes)
+	return scopes
+}
+
+// managementEntitlements builds one entitlement per enabled admin permission scope.
+func managementEntitlements(resource *v2.Resource, permissions *gocloak.ManagementPermissionRepresentation) []*v2.Entitlement {
+	scopes := managementScopes(permissions)
+	entitlements := make([]*v2.Entitlement, 0, len(scopes))
+	for _, scope := range scopes {
+		entitlements = append(entitlements, managementEntitlement(resource, scope))
+	}
+	return entitlements
+}
+
+func managementEntitlement(resource *v2.Resource, scope string) *v2.Entitlement {
+	return &v2.Entitlement{
+		Id:          entitlementID(resource.Id.ResourceType, resource.Id.Resource, "permission:"+scope),
+		DisplayName: fmt.Sprintf("%s permission on %s", scope, resource.DisplayName),
+		Description: fmt.Sprintf("Delegated admin permission to %s the %s %s", scope, resource.DisplayName, resource.Id.ResourceType),
+		GrantableTo: []*v2.ResourceType{userResourceType, groupResourceType, roleResourceType},
+		Slug:        scope,
+		Resource:    resource,
+	}
+}
+
+// managementGrants resolves the policies of every enabled admin permission scope
+// on resource into grants.
+func (c *Connector) managementGrants(ctx context.Context, resource *v2.Resource, permissions *gocloak.ManagementPermissionRepresentation) ([]*v2.Grant, error) {
+	scopes := managementScopes(permissions)
+	if len(scopes) == 0 {
+		return nil, nil
+	}
+
+	idOfClient, err := c.client.RealmManagementClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var grants []*v2.Grant
+	for _, scope := range scopes {
+		permissionID := (*permissions.ScopePermissions)[scope]
+		entitlement := managementEntitlement(resource, scope)
+
+		principals, err := c.policyPrincipals(ctx, idOfClient, permissionID, map[string]bool{})
+		if err != nil {
+			return nil, err
+		}
+
+		granted := make(map[string]bool)
+		for _, principal := range principals {
+			// Several policies of one permission may name the same principal.
+			if granted[principal.Id.Resource] {
+				continue
+			}
+			granted[principal.Id.Resource] = true
+
+			grants = append(grants, policyGrant(entitlement, principal))
+		}
+	}
+
+	return grants, nil
+}
+
+// policyGrant grants entitlement to a principal named by a policy. Groups and
+// roles pass the grant on to their members.
+func policyGrant(entitlement *v2.Entitlement, principal *v2.Resource) *v2.Grant {
+	grant := &v2.Grant{
+		Id:          fmt.Sprintf("grant:%s:%s", entitlement.Id, principal.Id.Resource),
+		Entitlement: entitlement,
+		Principal:   principal,
+	}
+
+	if expansion := memberExpansion(principal); expansion != nil {
+		grant.Annotations = annotations.New(expansion)
+	}
+
+	return grant
+}
+
+// policyPrincipals returns the users, groups and roles named by the policies
+// associated with policyID in the resource server of idOfClient, following
+// aggregated policies. seen guards against aggregation cycles.
+func (c *Connector) policyPrincipals(ctx context.Context, idOfClient, policyID string, seen map[string]bool) ([]*v2.Resource, error) {
+	if seen[policyID] {
+		return nil, nil
+	}
+	seen[policyID] = true
+
+	policies, err := c.client.GetAssociatedPolicies(ctx, idOfClient, policyID)
+	if err != nil {
+		return nil, err
+	}
+
+	var principals []*v2.Resource
+	for _, policy := range policies {
+		if policy.Logic != nil && *policy.Logic == *gocloak.NEGATIVE {
+			continue
+		}
+
+		policyType := safeString(policy.Type)
+		if policyType == "aggregate" {
+			nested, err := c.policyPrincipals(ctx, idOfClient, safeString(policy.ID), seen)
+			if err != nil {
+				return nil, err
+			}
+			principals = append(principals, nested...)
+			continue
+		}
+
+		ids, resourceType, err := policyConfigIDs(policy)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			principals = append(principals, eventResource(resourceType, id))
+		}
+	}
+
+	return principals, nil
+}
+
+// policyConfigIDs extracts the IDs a user, group or role policy refers to. The
+// admin API returns them JSON encoded inside the policy config.
+func policyConfigIDs(policy *gocloak.PolicyRepresentation) ([]string, *v2.ResourceType, error) {
+	if policy.Config == nil {
+		return nil, nil, nil
+	}
+	config := *policy.Config
+
+	switch safeString(policy.Type) {
+	case "user":
+		var ids []string
+		if err := unmarshalPolicyConfig(config["users"], &ids); err != nil {
+			return nil, nil, fmt.Errorf("policy %s: %w", safeString(policy.Name), err)
+		}
+		return ids, userResourceType, nil
+
+	case "group":
+		var groups []struct {
+			ID string `json:"id"`
+		}
+		if err := unmarshalPolicyConfig(config["groups"], &groups); err != nil {
+			return nil, nil, fmt.Errorf("policy %s: %w", safeString(policy.Name), err)
+		}
+		ids := make([]string, 0, len(groups))
+		for _, group := range groups {
+			ids = append(ids, group.ID)
+		}
+		return ids, groupResourceType, nil
+
+	case "role":
+		var roles []struct {
+			ID string `json:"id"`
+		}
+		if err := unmarshalPolicyConfig(config["roles"], &roles); err != nil {
+			return nil, nil, fmt.Errorf("policy %s: %w", safeString(policy.Name), err)
+		}
+		ids := make([]string, 0, len(roles))
+		for _, role := range roles {
+			ids = append(ids, role.ID)
+		}
+		return ids, roleResourceType, nil
+	}
+
+	return nil, nil, nil
+}
+
+func unmarshalPolicyConfig(raw string, v interface{}) error {
+	if raw == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(raw), v); err != nil {
+		return fmt.Errorf("invalid policy config: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,383 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+	"github.com/spiros-spiros/baton-keycloak/pkg/utils"
+)
+
+const (
+	// authzRefreshInterval keeps a sync from re-evaluating a client's
+	// permissions for every one of its resources.
+	authzRefreshInterval = 15 * time.Minute
+	// authzAccessScope stands in for resources that define no scopes, where a
+	// resource based permission grants plain access.
+	authzAccessScope = "access"
+)
+
+// authzResourceBuilder syncs the Authorization Services resources of a client.
+// Each scope of a resource becomes an entitlement, granted to whoever the
+// client's permissions and user, group or role policies hand it to.
+type authzResourceBuilder struct {
+	resourceType *v2.ResourceType
+	client       *Connector
+}
+
+func (o *authzResourceBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
+	return authzResourceResourceType
+}
+
+func (o *authzResourceBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	if parentResourceID == nil || parentResourceID.ResourceType != clientResourceType.Id {
+		return nil, "", nil, nil
+	}
+
+	authzResources, nextToken, err := o.client.client.GetAuthzResources(ctx, parentResourceID.Resource, utils.ParseToken(pToken))
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	resources := make([]*v2.Resource, 0, len(authzResources))
+	for _, authzResource := range authzResources {
+		ret, err := resource.NewResource(
+			authzResourceName(authzResource),
+			authzResourceResourceType,
+			safeString(authzResource.ID),
+			resource.WithParentResourceID(parentResourceID),
+			resource.WithDescription(safeString(authzResource.Type)),
+		)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		resources = append(resources, ret)
+	}
+
+	return resources, nextToken, nil, nil
+}
+
+func (o *authzResourceBuilder) Entitlements(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	model, err := o.client.authz.Model(ctx, resource.ParentResourceId.Resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	scopes := model.scopes(resource.Id.Resource)
+	entitlements := make([]*v2.Entitlement, 0, len(scopes))
+	for _, scope := range scopes {
+		entitlements = append(entitlements, authzScopeEntitlement(resource, scope))
+	}
+
+	return entitlements, "", nil, nil
+}
+
+func (o *authzResourceBuilder) Grants(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	model, err := o.client.authz.Model(ctx, resource.ParentResourceId.Resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	var grants []*v2.Grant
+	for _, scope := range model.scopes(resource.Id.Resource) {
+		entitlement := authzScopeEntitlement(resource, scope)
+		for _, principal := range model.access[resource.Id.Resource][scope] {
+			grants = append(grants, policyGrant(entitlement, principal))
+		}
+	}
+
+	return grants, "", nil, nil
+}
+
+func authzScopeEntitlement(resource *v2.Resource, scope string) *v2.Entitlement {
+	return &v2.Entitlement{
+		Id:          entitlementID(authzResourceResourceType.Id, resource.Id.Resource, "scope:"+scope),
+		DisplayName: fmt.Sprintf("%s on %s", scope, resource.DisplayName),
+		Description: fmt.Sprintf("The %s scope on the %s authorization resource", scope, resource.DisplayName),
+		GrantableTo: []*v2.ResourceType{userResourceType, groupResourceType, roleResourceType},
+		Slug:        scope,
+		Resource:    resource,
+	}
+}
+
+// authzScopeBuilder syncs the Authorization Services scopes of a client as
+// read-only resources. Who holds a scope is reported on the resources using it.
+type authzScopeBuilder struct {
+	resourceType *v2.ResourceType
+	client       *Connector
+}
+
+func (o *authzScopeBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
+	return authzScopeResourceType
+}
+
+func (o *authzScopeBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	if parentResourceID == nil || parentResourceID.ResourceType != clientResourceType.Id {
+		return nil, "", nil, nil
+	}
+
+	scopes, nextToken, err := o.client.client.GetAuthzScopes(ctx, parentResourceID.Resource, utils.ParseToken(pToken))
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	resources := make([]*v2.Resource, 0, len(scopes))
+	for _, scope := range scopes {
+		name := safeString(scope.DisplayName)
+		if name == "" {
+			name = safeString(scope.Name)
+		}
+
+		ret, err := resource.NewResource(
+			name,
+			authzScopeResourceType,
+			safeString(scope.ID),
+			resource.WithParentResourceID(parentResourceID),
+		)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		resources = append(resources, ret)
+	}
+
+	return resources, nextToken, nil, nil
+}
+
+func (o *authzScopeBuilder) Entitlements(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+func (o *authzScopeBuilder) Grants(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+// authzModel is the evaluated Authorization Services setup of one client.
+type authzModel struct {
+	loadedAt time.Time
+	// resourceScopes maps resource IDs to the names of their scopes.
+	resourceScopes map[string][]string
+	// access maps resource IDs, then scope names, to the principals of the
+	// permissions covering them.
+	access map[string]map[string][]*v2.Resource
+}
+
+// scopes returns the entitlement scopes of a resource.
+func (m *authzModel) scopes(resourceID string) []string {
+	if scopes := m.resourceScopes[resourceID]; len(scopes) > 0 {
+		return scopes
+	}
+	return []string{authzAccessScope}
+}
+
+func (m *authzModel) grant(resourceID, scope string, principals []*v2.Resource) {
+	if m.access[resourceID] == nil {
+		m.access[resourceID] = make(map[string][]*v2.Resource)
+	}
+
+	for _, principal := range principals {
+		duplicate := slices.ContainsFunc(m.access[resourceID][scope], func(existing *v2.Resource) bool {
+			return existing.Id.Resource == principal.Id.Resource
+		})
+		if !duplicate {
+			m.access[resourceID][scope] = append(m.access[resourceID][scope], principal)
+		}
+	}
+}
+
+// authzModels evaluates and caches the Authorization Services setup per client.
+type authzModels struct {
+	connector *Connector
+
+	mu     sync.Mutex
+	models map[string]*authzModel
+}
+
+func newAuthzModels(connector *Connector) *authzModels {
+	return &authzModels{
+		connector: connector,
+		models:    make(map[string]*authzModel),
+	}
+}
+
+// Model returns the evaluated setup of the client, reusing a recent evaluation.
+//
+// Permissions are evaluated as if every decision were affirmative: anyone named
+// by a positive user, group or role policy of a permission is granted what the
+// permission covers. A resource based permission covers every scope of its
+// resources, or of every resource of its resource type. A scope based
+// permission covers its scopes on its resources, or on every resource
+// offering those scopes when it names no resource.
+func (a *authzModels) Model(ctx context.Context, idOfClient string) (*authzModel, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if model, ok := a.models[idOfClient]; ok && time.Since(model.loadedAt) < authzRefreshInterval {
+		return model, nil
+	}
+
+	client := a.connector.client
+	model := &authzModel{
+		loadedAt:       time.Now(),
+		resourceScopes: make(map[string][]string),
+		access:         make(map[string]map[string][]*v2.Resource),
+	}
+
+	resourceTypes := make(map[string][]string)
+	err := forEachPage(func(first int) (string, error) {
+		resources, nextToken, err := client.GetAuthzResources(ctx, idOfClient, first)
+		for _, r := range resources {
+			id := safeString(r.ID)
+			model.resourceScopes[id] = scopeNames(r.Scopes)
+			if resourceType := safeString(r.Type); resourceType != "" {
+				resourceTypes[resourceType] = append(resourceTypes[resourceType], id)
+			}
+		}
+		return nextToken, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var permissions []*gocloak.PermissionRepresentation
+	err = forEachPage(func(first int) (string, error) {
+		page, nextToken, err := client.GetAuthzPermissions(ctx, idOfClient, first)
+		permissions = append(permissions, page...)
+		return nextToken, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, permission := range permissions {
+		if permission.Logic != nil && *permission.Logic == *gocloak.NEGATIVE {
+			continue
+		}
+		permissionID := safeString(permission.ID)
+
+		principals, err := a.connector.policyPrincipals(ctx, idOfClient, permissionID, map[string]bool{})
+		if err != nil {
+			return nil, err
+		}
+		if len(principals) == 0 {
+			continue
+		}
+
+		resourceIDs, scopes, err := permissionTargets(ctx, client, idOfClient, permissionID)
+		if err != nil {
+			return nil, err
+		}
+
+		switch safeString(permission.Type) {
+		case "resource":
+			if resourceType := safeString(permission.ResourceType); resourceType != "" {
+				resourceIDs = append(resourceIDs, resourceTypes[resourceType]...)
+			}
+			for _, resourceID := range resourceIDs {
+				for _, scope := range model.scopes(resourceID) {
+					model.grant(resourceID, scope, principals)
+				}
+			}
+
+		case "scope":
+			if len(resourceIDs) == 0 {
+				for resourceID, resourceScopes := range model.resourceScopes {
+					if slices.ContainsFunc(scopes, func(scope string) bool { return slices.Contains(resourceScopes, scope) }) {
+						resourceIDs = append(resourceIDs, resourceID)
+					}
+				}
+			}
+			for _, resourceID := range resourceIDs {
+				for _, scope := range scopes {
+					if slices.Contains(model.resourceScopes[resourceID], scope) {
+						model.grant(resourceID, scope, principals)
+					}
+				}
+			}
+		}
+	}
+
+	a.models[idOfClient] = model
+	return model, nil
+}
+
+// permissionTargets returns the resource IDs and scope names a permission names explicitly.
+func permissionTargets(ctx context.Context, client keycloak.API, idOfClient, permissionID string) ([]string, []string, error) {
+	permissionResources, err := client.GetAuthzPermissionResources(ctx, idOfClient, permissionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	resourceIDs := make([]string, 0, len(permissionResources))
+	for _, r := range permissionResources {
+		resourceIDs = append(resourceIDs, safeString(r.ResourceID))
+	}
+
+	permissionScopes, err := client.GetAuthzPermissionScopes(ctx, idOfClient, permissionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	scopes := make([]string, 0, len(permissionScopes))
+	for _, scope := range permissionScopes {
+		scopes = append(scopes, safeString(scope.ScopeName))
+	}
+
+	return resourceIDs, scopes, nil
+}
+
+func authzResourceName(r *gocloak.ResourceRepresentation) string {
+	if name := safeString(r.DisplayName); name != "" {
+		return name
+	}
+	return safeString(r.Name)
+}
+
+func scopeNames(scopes *[]gocloak.ScopeRepresentation) []string {
+	if scopes == nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(*scopes))
+	for _, scope := range *scopes {
+		names = append(names, safeString(scope.Name))
+	}
+	slices.Sort(names)
+	return names
+}
+
+// forEachPage calls fetch with increasing offsets until it returns an empty next page token.
+func forEachPage(fetch func(first int) (string, error)) error {
+	for first := 0; ; {
+		nextToken, err := fetch(first)
+		if err != nil {
+			return err
+		}
+		if nextToken == "" {
+			return nil
+		}
+		if first, err = strconv.Atoi(nextToken); err != nil {
+			return err
+		}
+	}
+}
+
+func newAuthzResourceBuilder(client *Connector) *authzResourceBuilder {
+	return &authzResourceBuilder{
+		resourceType: authzResourceResourceType,
+		client:       client,
+	}
+}
+
+func newAuthzScopeBuilder(client *Connector) *authzScopeBuilder {
+	return &authzScopeBuilder{
+		resourceType: authzScopeResourceType,
+		client:       client,
+	}
+}
@@ -0,0 +1,173 @@
+package connector
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/spiros-spiros/baton-keycloak/pkg/utils"
+	"google.golang.org/protobuf/proto"
+)
+
+// secretCreationTimeAttribute is the client attribute holding when the secret
+// was generated.
+const secretCreationTimeAttribute = "client.secret.creation.time"
+
+// clientBuilder syncs Keycloak clients. Their entitlements are the fine-grained
+// admin permissions on the client, and they are the parents of the client roles
+// synced by roleBuilder and, when Authorization Services are enabled, of its
+// authorization resources and scopes. Clients with service accounts enabled are
+// also the parents of their service-account user.
+type clientBuilder struct {
+	resourceType *v2.ResourceType
+	client       *Connector
+}
+
+func (o *clientBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
+	return clientResourceType
+}
+
+func (o *clientBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	clients, nextToken, err := o.client.client.GetClients(ctx, utils.ParseToken(pToken))
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	resources := make([]*v2.Resource, 0, len(clients))
+	for _, client := range clients {
+		if !o.client.scope.includesClient(client) {
+			continue
+		}
+
+		clientResource, err := parseIntoClientResource(client, parentResourceID)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		resources = append(resources, clientResource)
+	}
+
+	return resources, nextToken, nil, nil
+}
+
+// Entitlements returns one entitlement per enabled fine-grained admin permission
+// of the client.
+func (o *clientBuilder) Entitlements(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	permissions, err := o.client.client.GetClientManagementPermissions(ctx, resource.Id.Resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return managementEntitlements(resource, permissions), "", nil, nil
+}
+
+// Grants returns the delegated admins of the client.
+func (o *clientBuilder) Grants(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	permissions, err := o.client.client.GetClientManagementPermissions(ctx, resource.Id.Resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	grants, err := o.client.managementGrants(ctx, resource, permissions)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return grants, "", nil, nil
+}
+
+func parseIntoClientResource(client *gocloak.Client, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
+	clientID := safeString(client.ClientID)
+
+	profile := map[string]interface{}{
+		"client_id":                clientID,
+		"name":                     safeString(client.Name),
+		"protocol":                 safeString(client.Protocol),
+		"enabled":                  client.Enabled == nil || *client.Enabled,
+		"access_type":              clientAccessType(client),
+		"service_accounts_enabled": serviceAccountsEnabled(client),
+	}
+	if client.RedirectURIs != nil && len(*client.RedirectURIs) > 0 {
+		profile["redirect_uris"] = strings.Join(*client.RedirectURIs, ", ")
+	}
+	if rotatedAt, ok := secretRotatedAt(client); ok {
+		profile["secret_rotated_at"] = rotatedAt.UTC().Format(time.RFC3339)
+	}
+
+	appTraits := []resource.AppTraitOption{
+		resource.WithAppProfile(profile),
+	}
+
+	childTypes := []proto.Message{
+		&v2.ChildResourceType{ResourceTypeId: roleResourceType.Id},
+	}
+	if serviceAccountsEnabled(client) {
+		childTypes = append(childTypes, &v2.ChildResourceType{ResourceTypeId: userResourceType.Id})
+	}
+	if client.AuthorizationServicesEnabled != nil && *client.AuthorizationServicesEnabled {
+		childTypes = append(childTypes,
+			&v2.ChildResourceType{ResourceTypeId: authzResourceResourceType.Id},
+			&v2.ChildResourceType{ResourceTypeId: authzScopeResourceType.Id},
+		)
+	}
+
+	// Built-in clients carry localisation keys such as ${client_account} as
+	// their name, the clientId is what admins recognise.
+	ret, err := resource.NewAppResource(
+		clientID,
+		clientResourceType,
+		safeString(client.ID),
+		appTraits,
+		resource.WithParentResourceID(parentResourceID),
+		resource.WithDescription(safeString(client.Description)),
+		resource.WithAnnotation(childTypes...),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// clientAccessType is the access type the admin console shows for the client.
+func clientAccessType(client *gocloak.Client) string {
+	switch {
+	case client.BearerOnly != nil && *client.BearerOnly:
+		return "bearer-only"
+	case client.PublicClient != nil && *client.PublicClient:
+		return "public"
+	default:
+		return "confidential"
+	}
+}
+
+func serviceAccountsEnabled(client *gocloak.Client) bool {
+	return client.ServiceAccountsEnabled != nil && *client.ServiceAccountsEnabled
+}
+
+// secretRotatedAt reads when the client secret was last generated. Keycloak
+// records it as a client attribute in seconds since the epoch.
+func secretRotatedAt(client *gocloak.Client) (time.Time, bool) {
+	if client.Attributes == nil {
+		return time.Time{}, false
+	}
+
+	created, err := strconv.ParseInt((*client.Attributes)[secretCreationTimeAttribute], 10, 64)
+	if err != nil || created <= 0 {
+		return time.Time{}, false
+	}
+
+	return time.Unix(created, 0), true
+}
+
+func newClientBuilder(client *Connector) *clientBuilder {
+	return &clientBuilder{
+		resourceType: clientResourceType,
+		client:       client,
+	}
+}
@@ -0,0 +1,257 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+)
+
+// scopeClientsRefreshInterval keeps a single sync from listing every client for
+// each client scope while still picking up changes on the next sync.
+const scopeClientsRefreshInterval = 15 * time.Minute
+
+// clientScopeUse is a client using a client scope, by default or optionally.
+type clientScopeUse struct {
+	client *gocloak.Client
+	slug   string
+}
+
+// scopeClients maps client scope names to the clients using them, read from
+// one listing of the clients and kept for a while.
+type scopeClients struct {
+	client keycloak.API
+
+	mu       sync.Mutex
+	loadedAt time.Time
+	byScope  map[string][]clientScopeUse
+}
+
+func newScopeClients(client keycloak.API) *scopeClients {
+	return &scopeClients{client: client}
+}
+
+func (s *scopeClients) get(ctx context.Context) (map[string][]clientScopeUse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.byScope != nil && time.Since(s.loadedAt) < scopeClientsRefreshInterval {
+		return s.byScope, nil
+	}
+
+	byScope := make(map[string][]clientScopeUse)
+	err := forEachPage(func(first int) (string, error) {
+		clients, nextToken, err := s.client.GetClients(ctx, first)
+		for _, client := range clients {
+			var defaultScopes, optionalScopes []string
+			if client.DefaultClientScopes != nil {
+				defaultScopes = *client.DefaultClientScopes
+			}
+			if client.OptionalClientScopes != nil {
+				optionalScopes = *client.OptionalClientScopes
+			}
+
+			for _, name := range defaultScopes {
+				byScope[name] = append(byScope[name], clientScopeUse{client: client, slug: "default"})
+			}
+			for _, name := range optionalScopes {
+				if !slices.Contains(defaultScopes, name) {
+					byScope[name] = append(byScope[name], clientScopeUse{client: client, slug: "optional"})
+				}
+			}
+		}
+		return nextToken, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.byScope = byScope
+	s.loadedAt = time.Now()
+	return byScope, nil
+}
+
+// clientScopeBuilder syncs the realm's client scopes as read-only resources.
+// Which clients use a scope, by default or optionally, and which roles the
+// scope lets into tokens are reported as immutable grants, so reviewers can
+// inspect them but never request them.
+type clientScopeBuilder struct {
+	resourceType *v2.ResourceType
+	client       *Connector
+	clients      *scopeClients
+}
+
+func (o *clientScopeBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
+	return clientScopeResourceType
+}
+
+// List returns every client scope on a single page, Keycloak does not paginate them.
+func (o *clientScopeBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, _ *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	scopes, err := o.client.client.GetClientScopes(ctx)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	defaultScopes, optionalScopes, err := o.client.client.GetRealmDefaultClientScopes(ctx)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	resources := make([]*v2.Resource, 0, len(scopes))
+	for _, scope := range scopes {
+		realmDefault := "none"
+		switch {
+		case containsClientScope(defaultScopes, scope):
+			realmDefault = "default"
+		case containsClientScope(optionalScopes, scope):
+			realmDefault = "optional"
+		}
+
+		scopeResource, err := parseIntoClientScopeResource(scope, realmDefault, parentResourceID)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		resources = append(resources, scopeResource)
+	}
+
+	return resources, "", nil, nil
+}
+
+func (o *clientScopeBuilder) Entitlements(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	return []*v2.Entitlement{
+		clientScopeEntitlement(resource, "default"),
+		clientScopeEntitlement(resource, "optional"),
+		clientScopeEntitlement(resource, "role-scope"),
+	}, "", nil, nil
+}
+
+func (o *clientScopeBuilder) Grants(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	var grants []*v2.Grant
+
+	// Clients reference their scopes by name.
+	uses, err := o.clients.get(ctx)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	for _, use := range uses[resource.DisplayName] {
+		if !o.client.scope.includesClient(use.client) {
+			continue
+		}
+
+		clientResource, err := parseIntoClientResource(use.client, nil)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		grants = append(grants, clientScopeGrant(clientScopeEntitlement(resource, use.slug), clientResource))
+	}
+
+	mappings, err := o.client.client.GetClientScopeScopeMappings(ctx, resource.Id.Resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	roleScope := clientScopeEntitlement(resource, "role-scope")
+	for _, role := range mappedRoles(mappings) {
+		grants = append(grants, clientScopeGrant(roleScope, eventResource(roleResourceType, safeString(role.ID))))
+	}
+
+	return grants, "", nil, nil
+}
+
+func clientScopeEntitlement(resource *v2.Resource, slug string) *v2.Entitlement {
+	entitlement := &v2.Entitlement{
+		Id:          entitlementID(clientScopeResourceType.Id, resource.Id.Resource, slug),
+		Slug:        slug,
+		Resource:    resource,
+		Annotations: annotations.New(&v2.EntitlementImmutable{}),
+	}
+
+	switch slug {
+	case "default":
+		entitlement.DisplayName = fmt.Sprintf("%s as default scope", resource.DisplayName)
+		entitlement.Description = fmt.Sprintf("Clients always including the %s client scope in their tokens", resource.DisplayName)
+		entitlement.GrantableTo = []*v2.ResourceType{clientResourceType}
+	case "optional":
+		entitlement.DisplayName = fmt.Sprintf("%s as optional scope", resource.DisplayName)
+		entitlement.Description = fmt.Sprintf("Clients including the %s client scope when it is requested", resource.DisplayName)
+		entitlement.GrantableTo = []*v2.ResourceType{clientResourceType}
+	case "role-scope":
+		entitlement.DisplayName = fmt.Sprintf("Roles in %s", resource.DisplayName)
+		entitlement.Description = fmt.Sprintf("Roles the %s client scope lets into tokens", resource.DisplayName)
+		entitlement.GrantableTo = []*v2.ResourceType{roleResourceType}
+	}
+
+	return entitlement
+}
+
+func clientScopeGrant(entitlement *v2.Entitlement, principal *v2.Resource) *v2.Grant {
+	return &v2.Grant{
+		Id:          fmt.Sprintf("grant:%s:%s", entitlement.Id, principal.Id.Resource),
+		Entitlement: entitlement,
+		Principal:   principal,
+		Annotations: annotations.New(&v2.GrantImmutable{}),
+	}
+}
+
+// mappedRoles flattens the realm and client roles of a scope mapping.
+func mappedRoles(mappings *gocloak.MappingsRepresentation) []gocloak.Role {
+	var roles []gocloak.Role
+	if mappings.RealmMappings != nil {
+		roles = append(roles, *mappings.RealmMappings...)
+	}
+	for _, clientMappings := range mappings.ClientMappings {
+		if clientMappings != nil && clientMappings.Mappings != nil {
+			roles = append(roles, *clientMappings.Mappings...)
+		}
+	}
+	return roles
+}
+
+func containsClientScope(scopes []*gocloak.ClientScope, scope *gocloak.ClientScope) bool {
+	return slices.ContainsFunc(scopes, func(s *gocloak.ClientScope) bool {
+		return safeString(s.ID) == safeString(scope.ID)
+	})
+}
+
+// parseIntoClientScopeResource converts a client scope into a resource. Client
+// scopes have no matching trait, so what reviewers need to know about them goes
+// into the description.
+func parseIntoClientScopeResource(scope *gocloak.ClientScope, realmDefault string, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
+	description := []string{
+		fmt.Sprintf("Protocol: %s", safeString(scope.Protocol)),
+		fmt.Sprintf("Realm default: %s", realmDefault),
+	}
+	if desc := safeString(scope.Description); desc != "" {
+		description = append(description, desc)
+	}
+
+	ret, err := resource.NewResource(
+		safeString(scope.Name),
+		clientScopeResourceType,
+		safeString(scope.ID),
+		resource.WithParentResourceID(parentResourceID),
+		resource.WithDescription(strings.Join(description, ". ")),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+func newClientScopeBuilder(client *Connector) *clientScopeBuilder {
+	return &clientScopeBuilder{
+		resourceType: clientScopeResourceType,
+		client:       client,
+		clients:      newScopeClients(client.client),
+	}
+}
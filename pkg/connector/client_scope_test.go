@@ -0,0 +1,77 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak/fake"
+)
+
+// clientListings counts the listings of the realm's clients.
+type clientListings struct {
+	*fake.Keycloak
+	listings int
+}
+
+func (k *clientListings) GetClients(ctx context.Context, first int) ([]*gocloak.Client, string, error) {
+	if first == 0 {
+		k.listings++
+	}
+	return k.Keycloak.GetClients(ctx, first)
+}
+
+func TestClientScopeGrantsListClientsOnce(t *testing.T) {
+	ctx := context.Background()
+	kc := &clientListings{Keycloak: fake.New(testRealm)}
+	kc.PageSize = 2
+	var scopes []string
+	for i := range 5 {
+		name := fmt.Sprintf("scope-%d", i)
+		scopes = append(scopes, name)
+		kc.AddClientScope(gocloak.ClientScope{Name: gocloak.StringP(name)}, "none")
+	}
+	for i := range 4 {
+		kc.AddClient(gocloak.Client{
+			ClientID:             gocloak.StringP(fmt.Sprintf("client-%d", i)),
+			DefaultClientScopes:  &[]string{"scope-0", scopes[i+1]},
+			OptionalClientScopes: &[]string{"scope-0", "scope-1"},
+		})
+	}
+	c, err := newConnector(kc, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := newClientScopeBuilder(c)
+
+	got := make(map[string][]string)
+	for _, scope := range listAll(t, builder, nil) {
+		grants, _, _, err := builder.Grants(ctx, scope, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, grant := range grants {
+			if grant.Principal.Id.ResourceType == clientResourceType.Id {
+				got[scope.DisplayName] = append(got[scope.DisplayName], grant.Principal.DisplayName+" "+grant.Entitlement.Slug)
+			}
+		}
+	}
+
+	if kc.listings != 1 {
+		t.Errorf("listed the clients %d times for %d client scopes, want once", kc.listings, len(scopes))
+	}
+	want := map[string][]string{
+		"scope-0": {"client-0 default", "client-1 default", "client-2 default", "client-3 default"},
+		"scope-1": {"client-0 default", "client-1 optional", "client-2 optional", "client-3 optional"},
+		"scope-2": {"client-1 default"},
+		"scope-3": {"client-2 default"},
+		"scope-4": {"client-3 default"},
+	}
+	for name, clients := range want {
+		if !slices.Equal(got[name], clients) {
+			t.Errorf("%s grants: %v, want %v", name, got[name], clients)
+		}
+	}
+}
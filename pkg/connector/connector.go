@@ -2,30 +2,61 @@ package connector
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"time"
 
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
 	"github.com/conductorone/baton-sdk/pkg/annotations"
 	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
+	"github.com/conductorone/baton-sdk/pkg/metrics"
 	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
 	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
 	"go.uber.org/zap"
 )
 
 type Connector struct {
-	client       *keycloak.Client
+	client       keycloak.API
+	usage        *usageTracker
+	authz        *authzModels
+	defaults     *realmDefaults
 	serverURL    string
 	realm        string
 	clientID     string
 	clientSecret string
+	// defaultGrantDuration bounds group memberships granted by the connector,
+	// 0 leaves them until revoked.
+	defaultGrantDuration time.Duration
+	// protection lists the groups and roles provisioning must not touch.
+	protection *protectionPolicy
+	// scope selects the part of the realm that is synced.
+	scope *syncScope
+	// groupsByPath displays groups by their full path rather than their name.
+	groupsByPath bool
+	// cache is only set when incremental sync is enabled.
+	cache *syncCache
+	// events holds the event feed's backlog between ListEvents calls.
+	events *eventFeed
 }
 
 // ResourceSyncers returns ResourceSyncer for each resource type that should be synced from the upstream service.
 func (c *Connector) ResourceSyncers(ctx context.Context) []connectorbuilder.ResourceSyncer {
-	return []connectorbuilder.ResourceSyncer{
+	syncers := []connectorbuilder.ResourceSyncer{
+		newRealmBuilder(c),
 		newUserBuilder(c),
 		newGroupBuilder(c),
+		newClientBuilder(c),
+		newRoleBuilder(c),
+		newClientScopeBuilder(c),
+		newAuthzResourceBuilder(c),
+		newAuthzScopeBuilder(c),
 	}
+
+	// Every builder reports Keycloak's rate limits the same way.
+	for i, syncer := range syncers {
+		syncers[i] = c.rateLimited(syncer)
+	}
+	return syncers
 }
 
 // Asset takes an input AssetRef and attempts to fetch it using the connector's authenticated http client
@@ -38,7 +69,7 @@ func (c *Connector) Asset(ctx context.Context, asset *v2.AssetRef) (string, io.R
 func (c *Connector) Metadata(ctx context.Context) (*v2.ConnectorMetadata, error) {
 	return &v2.ConnectorMetadata{
 		DisplayName: "Keycloak",
-		Description: "Connector syncing users and groups from Keycloak",
+		Description: "Connector syncing the realm, users, groups, clients and roles from Keycloak",
 	}, nil
 }
 
@@ -55,20 +86,110 @@ func (c *Connector) Close() error {
 	return nil
 }
 
+// Config holds everything needed to build a Keycloak connector.
+type Config struct {
+	keycloak.Config
+	// IncrementalSync keeps users and groups between syncs and only refetches
+	// the ones admin events report as changed.
+	IncrementalSync bool
+	// GrantDuration makes group memberships granted by the connector expire
+	// after it, unless the group sets baton_grant_duration. 0 keeps them
+	// until revoked. Expired memberships are removed as users are synced,
+	// so only for users the sync scope includes.
+	GrantDuration time.Duration
+	// DryRun logs the requests that would change Keycloak instead of sending
+	// them, while provisioning reports them as successful.
+	DryRun bool
+	// ProtectedGroups and ProtectedRoles are never granted or revoked. Groups
+	// are given by path, roles by name or clientId/name, either as
+	// regex:<pattern> instead.
+	ProtectedGroups []string
+	ProtectedRoles  []string
+	// HideProtectedEntitlements marks the entitlements of protected groups
+	// and roles immutable, so they can't be requested.
+	HideProtectedEntitlements bool
+	// GroupPathPrefixes only syncs the top-level groups with these paths,
+	// e.g. /workforce, and their subgroups.
+	GroupPathPrefixes []string
+	// UserSearch only syncs the users Keycloak's search finds for it, over
+	// username, email, first and last name, see keycloak.UserQuery.
+	UserSearch string
+	// UserAttributes only syncs the users having all these key=value
+	// attributes.
+	UserAttributes []string
+	// UsersInGroupsOnly only syncs the users that are members of a synced
+	// group or one of its subgroups.
+	UsersInGroupsOnly bool
+	// ClientIDs only syncs the clients with these clientIds, along with
+	// their roles.
+	ClientIDs []string
+	// GroupDisplayName is how groups are displayed: GroupDisplayNameName,
+	// the default, or GroupDisplayNamePath to tell apart groups sharing a
+	// name under different parents.
+	GroupDisplayName string
+	// Metrics receives how long requests wait for the client-side rate and
+	// concurrency limits. Optional.
+	Metrics metrics.Handler
+}
+
 // Actually create a Keycloak connector.
-func New(ctx context.Context, keycloakServerURL string, keycloakRealm string, keycloakClientID string, keycloakClientSecret string) (*Connector, error) {
+func New(ctx context.Context, cfg Config) (*Connector, error) {
 	l := ctxzap.Extract(ctx)
-	keycloakClient, err := keycloak.NewClient(keycloakServerURL, keycloakRealm, keycloakClientID, keycloakClientSecret)
+	if cfg.Metrics != nil && cfg.WaitObserver == nil {
+		cfg.WaitObserver = requestWaitObserver(cfg.Metrics)
+	}
+
+	keycloakClient, err := keycloak.NewClient(cfg.Config)
 	if err != nil {
 		l.Error("error creating Keycloak client for some reason", zap.Error(err))
 		return nil, err
 	}
 
-	return &Connector{
-		client:       keycloakClient,
-		serverURL:    keycloakServerURL,
-		realm:        keycloakRealm,
-		clientID:     keycloakClientID,
-		clientSecret: keycloakClientSecret,
-	}, nil
+	if cfg.DryRun {
+		l.Info("dry run enabled, requests changing Keycloak are logged instead of sent")
+	}
+	return newConnector(keycloakClient, cfg)
+}
+
+// newConnector builds the connector around any implementation of the admin
+// API, tests pass the in-memory fake.
+func newConnector(client keycloak.API, cfg Config) (*Connector, error) {
+	protection, err := newProtectionPolicy(cfg.ProtectedGroups, cfg.ProtectedRoles, cfg.HideProtectedEntitlements)
+	if err != nil {
+		return nil, err
+	}
+	scope, err := newSyncScope(cfg)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.GroupDisplayName {
+	case "", GroupDisplayNameName, GroupDisplayNamePath:
+	default:
+		return nil, fmt.Errorf("invalid group display name %q, want %s or %s", cfg.GroupDisplayName, GroupDisplayNameName, GroupDisplayNamePath)
+	}
+	if cfg.DryRun {
+		client = newDryRunAPI(client, cfg.Realm)
+	}
+
+	connector := &Connector{
+		client:       client,
+		usage:        newUsageTracker(client),
+		defaults:     newRealmDefaults(client),
+		serverURL:    cfg.ServerURL,
+		realm:        cfg.Realm,
+		clientID:     cfg.ClientID,
+		clientSecret: cfg.ClientSecret,
+
+		defaultGrantDuration: cfg.GrantDuration,
+		protection:           protection,
+		scope:                scope,
+		groupsByPath:         cfg.GroupDisplayName == GroupDisplayNamePath,
+		events:               newEventFeed(),
+	}
+	connector.authz = newAuthzModels(connector)
+	if cfg.IncrementalSync {
+		connector.cache = newSyncCache(client)
+	}
+
+	return connector, nil
 }
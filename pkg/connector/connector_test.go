@@ -0,0 +1,64 @@
+package connector
+
+import (
+	"context"
+	"testing"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak/fake"
+)
+
+const testRealm = "test"
+
+func newTestConnector(kc *fake.Keycloak) *Connector {
+	// The zero Config has nothing to reject.
+	c, err := newConnector(kc, Config{})
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// listAll follows the pagination tokens of a builder's List to the end.
+func listAll(t *testing.T, syncer connectorbuilder.ResourceSyncer, parentResourceID *v2.ResourceId) []*v2.Resource {
+	t.Helper()
+
+	var (
+		resources []*v2.Resource
+		token     string
+	)
+	for page := 0; ; page++ {
+		if page > 100 {
+			t.Fatal("List did not stop paginating")
+		}
+
+		listed, next, _, err := syncer.List(context.Background(), parentResourceID, &pagination.Token{Token: token})
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		resources = append(resources, listed...)
+
+		if next == "" {
+			return resources
+		}
+		token = next
+	}
+}
+
+func resourceIDs(resources []*v2.Resource) []string {
+	ids := make([]string, 0, len(resources))
+	for _, r := range resources {
+		ids = append(ids, r.Id.Resource)
+	}
+	return ids
+}
+
+func principalIDs(grants []*v2.Grant) []string {
+	ids := make([]string, 0, len(grants))
+	for _, grant := range grants {
+		ids = append(ids, grant.Principal.Id.Resource)
+	}
+	return ids
+}
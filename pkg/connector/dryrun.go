@@ -0,0 +1,78 @@
+package connector
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+	"go.uber.org/zap"
+)
+
+// dryRunAPI reads from Keycloak but only logs the admin requests that would
+// change it, reporting them as successful. Grant, Revoke, the user actions and
+// the expiry reconciler all change Keycloak through these methods, so they
+// run unchanged and return the results they would have had.
+type dryRunAPI struct {
+	keycloak.API
+	realm string
+}
+
+func newDryRunAPI(client keycloak.API, realm string) *dryRunAPI {
+	return &dryRunAPI{API: client, realm: realm}
+}
+
+func (d *dryRunAPI) AddUserToGroup(ctx context.Context, userID, groupID string) error {
+	d.log(ctx, "PUT", []string{"users", userID, "groups", groupID})
+	return nil
+}
+
+func (d *dryRunAPI) RemoveUserFromGroup(ctx context.Context, userID, groupID string) error {
+	d.log(ctx, "DELETE", []string{"users", userID, "groups", groupID})
+	return nil
+}
+
+func (d *dryRunAPI) EnableUser(ctx context.Context, userID string) error {
+	d.log(ctx, "PUT", []string{"users", userID}, zap.Bool("enabled", true))
+	return nil
+}
+
+func (d *dryRunAPI) DisableUser(ctx context.Context, userID string) error {
+	d.log(ctx, "PUT", []string{"users", userID}, zap.Bool("enabled", false))
+	return nil
+}
+
+func (d *dryRunAPI) ClearUserBruteForceLockout(ctx context.Context, userID string) error {
+	d.log(ctx, "DELETE", []string{"attack-detection", "brute-force", "users", userID})
+	return nil
+}
+
+func (d *dryRunAPI) UpdateUserAttribute(ctx context.Context, userID, name string, update func(values []string) []string) error {
+	user, err := d.API.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	var current []string
+	if user.Attributes != nil {
+		current = (*user.Attributes)[name]
+	}
+
+	// Like the client, an update changing nothing sends nothing.
+	values := update(slices.Clone(current))
+	if slices.Equal(values, current) {
+		return nil
+	}
+	d.log(ctx, "PUT", []string{"users", userID}, zap.String("attribute", name), zap.Strings("values", values))
+	return nil
+}
+
+// log records a skipped request by its method and admin API path.
+func (d *dryRunAPI) log(ctx context.Context, method string, path []string, fields ...zap.Field) {
+	path = append([]string{"/admin/realms", d.realm}, path...)
+	fields = append([]zap.Field{
+		zap.String("method", method),
+		zap.String("path", strings.Join(path, "/")),
+	}, fields...)
+	ctxzap.Extract(ctx).Info("Dry run, skipping Keycloak request", fields...)
+}
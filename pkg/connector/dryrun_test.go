@@ -0,0 +1,69 @@
+package connector
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak/fake"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestDryRunGrantAndRevoke(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	ctx := ctxzap.ToContext(context.Background(), zap.New(core))
+
+	kc := fake.New(testRealm)
+	alice := kc.AddUser(gocloak.User{Username: gocloak.StringP("alice")})
+	admins := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("admins")}, "")
+	everyone := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("everyone")}, "")
+	if err := kc.AddUserToGroup(ctx, alice, everyone); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := newConnector(kc, Config{Config: keycloak.Config{Realm: testRealm}, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := newGroupBuilder(c)
+	users := listAll(t, newUserBuilder(c), nil)
+	groups := listAll(t, builder, nil)
+
+	grants, _, err := builder.Grant(ctx, users[0], groupMembershipEntitlement(groups[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grants) != 1 || grants[0].Id != "grant:"+admins+":"+alice {
+		t.Fatalf("Grant returned %v", grants)
+	}
+	if isMember(t, kc, alice, admins) {
+		t.Error("dry run Grant added alice to admins")
+	}
+
+	everyoneGrants, _, _, err := builder.Grants(ctx, groups[1], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := builder.Revoke(ctx, everyoneGrants[0]); err != nil {
+		t.Fatal(err)
+	}
+	if !isMember(t, kc, alice, everyone) {
+		t.Error("dry run Revoke removed alice from everyone")
+	}
+
+	var skipped []string
+	for _, entry := range logs.FilterMessage("Dry run, skipping Keycloak request").All() {
+		fields := entry.ContextMap()
+		skipped = append(skipped, fields["method"].(string)+" "+fields["path"].(string))
+	}
+	want := []string{
+		"PUT /admin/realms/test/users/" + alice + "/groups/" + admins,
+		"DELETE /admin/realms/test/users/" + alice + "/groups/" + everyone,
+	}
+	if len(skipped) != len(want) || skipped[0] != want[0] || skipped[1] != want[1] {
+		t.Errorf("skipped requests %v, want %v", skipped, want)
+	}
+}
@@ -0,0 +1,279 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v13"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
+	"github.com/conductorone/baton-sdk/pkg/connectorclient"
+	"github.com/conductorone/baton-sdk/pkg/dotc1z"
+	sdkSync "github.com/conductorone/baton-sdk/pkg/sync"
+	"github.com/conductorone/baton-sdk/pkg/types"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak/fake"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	e2eClientID     = "baton"
+	e2eClientSecret = "secret"
+)
+
+// TestEndToEnd syncs a realm served by the fake Keycloak over HTTP into a c1z,
+// grants and revokes a group membership through the connector, and checks
+// both Keycloak and the next sync see the change.
+func TestEndToEnd(t *testing.T) {
+	ctx := context.Background()
+
+	kc := fake.New(testRealm)
+	alice := kc.AddUser(gocloak.User{Username: gocloak.StringP("alice")})
+	bob := kc.AddUser(gocloak.User{Username: gocloak.StringP("bob")})
+	admins := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("admins")}, "")
+	auditor := kc.AddRealmRole(gocloak.Role{Name: gocloak.StringP("auditor")})
+	kc.MapUserRole(bob, auditor)
+	billing := kc.AddClient(gocloak.Client{
+		ClientID:               gocloak.StringP("billing"),
+		ServiceAccountsEnabled: gocloak.BoolP(true),
+	})
+	serviceAccount := kc.AddUser(gocloak.User{Username: gocloak.StringP("service-account-billing")})
+	kc.SetServiceAccount(billing, serviceAccount)
+	if err := kc.AddUserToGroup(ctx, alice, admins); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := fake.NewServer(kc, e2eClientID, e2eClientSecret)
+	defer srv.Close()
+
+	client := startConnector(t, Config{Config: keycloak.Config{
+		ServerURL:    srv.URL,
+		Realm:        testRealm,
+		ClientID:     e2eClientID,
+		ClientSecret: e2eClientSecret,
+	}})
+
+	c1z := syncToC1Z(t, client)
+
+	users := c1z.resources(t, userResourceType.Id)
+	for _, userID := range []string{alice, bob, serviceAccount} {
+		if users[userID] == nil {
+			t.Errorf("user %s missing from the sync", userID)
+		}
+	}
+	groups := c1z.resources(t, groupResourceType.Id)
+	if groups[admins] == nil {
+		t.Fatalf("group %s missing from the sync", admins)
+	}
+
+	membership := fmt.Sprintf("group:%s:membership", admins)
+	grants := c1z.grants(t)
+	if grants[grantKey(membership, alice)] == nil {
+		t.Errorf("alice's membership of admins missing from the sync")
+	}
+	if grants[grantKey(fmt.Sprintf("role:%s:assigned", auditor), bob)] == nil {
+		t.Errorf("bob's auditor role missing from the sync")
+	}
+	if grants[grantKey(membership, bob)] != nil {
+		t.Fatal("bob is synced as a member of admins before the grant")
+	}
+
+	entitlement := c1z.entitlement(t, membership)
+	if _, err := client.Grant(ctx, &v2.GrantManagerServiceGrantRequest{
+		Principal:   users[bob],
+		Entitlement: entitlement,
+	}); err != nil {
+		t.Fatalf("Grant: %v", err)
+	}
+	if !isMember(t, kc, bob, admins) {
+		t.Fatal("bob is not a member of admins after the grant")
+	}
+
+	c1z = syncToC1Z(t, client)
+	granted := c1z.grants(t)[grantKey(membership, bob)]
+	if granted == nil {
+		t.Fatal("bob's membership of admins missing from the sync after the grant")
+	}
+
+	if _, err := client.Revoke(ctx, &v2.GrantManagerServiceRevokeRequest{Grant: granted}); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if isMember(t, kc, bob, admins) {
+		t.Fatal("bob is still a member of admins after the revoke")
+	}
+
+	c1z = syncToC1Z(t, client)
+	if c1z.grants(t)[grantKey(membership, bob)] != nil {
+		t.Error("bob's membership of admins still synced after the revoke")
+	}
+}
+
+// startConnector serves the connector built from cfg over an in-memory gRPC
+// connection, the way the SDK's syncer talks to connectors.
+func startConnector(t *testing.T, cfg Config) types.ConnectorClient {
+	t.Helper()
+	ctx := context.Background()
+
+	cb, err := New(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := connectorbuilder.NewConnector(ctx, cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	v2.RegisterConnectorServiceServer(server, srv)
+	v2.RegisterResourceTypesServiceServer(server, srv)
+	v2.RegisterResourcesServiceServer(server, srv)
+	v2.RegisterResourceGetterServiceServer(server, srv)
+	v2.RegisterEntitlementsServiceServer(server, srv)
+	v2.RegisterGrantsServiceServer(server, srv)
+	v2.RegisterGrantManagerServiceServer(server, srv)
+	v2.RegisterAssetServiceServer(server, srv)
+	v2.RegisterEventServiceServer(server, srv)
+	v2.RegisterActionServiceServer(server, srv)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return connectorclient.NewConnectorClient(ctx, conn)
+}
+
+// syncedC1Z is the content of a finished sync.
+type syncedC1Z struct {
+	file *dotc1z.C1File
+}
+
+// syncToC1Z runs a full sync into a new c1z and opens it for reading.
+func syncToC1Z(t *testing.T, client types.ConnectorClient) *syncedC1Z {
+	t.Helper()
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "sync.c1z")
+	syncer, err := sdkSync.NewSyncer(ctx, client, sdkSync.WithC1ZPath(path), sdkSync.WithTmpDir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := syncer.Sync(ctx); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if err := syncer.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := dotc1z.NewC1ZFile(ctx, path, dotc1z.WithTmpDir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = file.Close() })
+
+	return &syncedC1Z{file: file}
+}
+
+// resources returns the synced resources of a type by ID.
+func (s *syncedC1Z) resources(t *testing.T, resourceTypeID string) map[string]*v2.Resource {
+	t.Helper()
+
+	resources := make(map[string]*v2.Resource)
+	pageToken := ""
+	for {
+		resp, err := s.file.ListResources(context.Background(), &v2.ResourcesServiceListResourcesRequest{
+			ResourceTypeId: resourceTypeID,
+			PageToken:      pageToken,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range resp.List {
+			resources[r.Id.Resource] = r
+		}
+
+		if pageToken = resp.NextPageToken; pageToken == "" {
+			return resources
+		}
+	}
+}
+
+// entitlement returns the synced entitlement with the ID.
+func (s *syncedC1Z) entitlement(t *testing.T, entitlementID string) *v2.Entitlement {
+	t.Helper()
+
+	pageToken := ""
+	for {
+		resp, err := s.file.ListEntitlements(context.Background(), &v2.EntitlementsServiceListEntitlementsRequest{
+			PageToken: pageToken,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entitlement := range resp.List {
+			if entitlement.Id == entitlementID {
+				return entitlement
+			}
+		}
+
+		if pageToken = resp.NextPageToken; pageToken == "" {
+			t.Fatalf("entitlement %s missing from the sync", entitlementID)
+		}
+	}
+}
+
+// grants returns every synced grant keyed by grantKey.
+func (s *syncedC1Z) grants(t *testing.T) map[string]*v2.Grant {
+	t.Helper()
+
+	grants := make(map[string]*v2.Grant)
+	pageToken := ""
+	for {
+		resp, err := s.file.ListGrants(context.Background(), &v2.GrantsServiceListGrantsRequest{
+			PageToken: pageToken,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, grant := range resp.List {
+			grants[grantKey(grant.Entitlement.Id, grant.Principal.Id.Resource)] = grant
+		}
+
+		if pageToken = resp.NextPageToken; pageToken == "" {
+			return grants
+		}
+	}
+}
+
+func grantKey(entitlementID, principalID string) string {
+	return entitlementID + " " + principalID
+}
+
+func isMember(t *testing.T, kc *fake.Keycloak, userID, groupID string) bool {
+	t.Helper()
+
+	members, err := kc.GetGroupMembers(context.Background(), groupID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, member := range members {
+		if gocloak.PString(member.ID) == userID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package connector
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"google.golang.org/protobuf/proto"
+)
+
+// Entitlement IDs are <resource type>:<resource ID>:<slug>, the IDs of
+// membership and role grants grant:<resource ID>:<principal ID>. ConductorOne
+// matches provisioning requests and later syncs on them, so the builders,
+// Grant, Revoke and the event feed all build them here.
+
+const (
+	membershipSlug = "membership"
+	assignedSlug   = "assigned"
+	defaultsSlug   = "defaults"
+)
+
+func entitlementID(resourceTypeID, resourceID, slug string) string {
+	return fmt.Sprintf("%s:%s:%s", resourceTypeID, resourceID, slug)
+}
+
+// parseEntitlementID returns the resource ID of an entitlement ID built by
+// entitlementID for resourceType and slug.
+func parseEntitlementID(id string, resourceType *v2.ResourceType, slug string) (string, error) {
+	resourceID, ok := strings.CutPrefix(id, resourceType.Id+":")
+	if ok {
+		resourceID, ok = strings.CutSuffix(resourceID, ":"+slug)
+	}
+	if !ok || resourceID == "" || strings.Contains(resourceID, ":") {
+		return "", fmt.Errorf("invalid entitlement ID format: %s", id)
+	}
+	return resourceID, nil
+}
+
+func groupMembershipEntitlementID(groupID string) string {
+	return entitlementID(groupResourceType.Id, groupID, membershipSlug)
+}
+
+func roleAssignedEntitlementID(roleID string) string {
+	return entitlementID(roleResourceType.Id, roleID, assignedSlug)
+}
+
+// groupMembershipEntitlement is the membership of a group resource.
+func groupMembershipEntitlement(group *v2.Resource) *v2.Entitlement {
+	return &v2.Entitlement{
+		Id:          groupMembershipEntitlementID(group.Id.Resource),
+		DisplayName: fmt.Sprintf("Membership in %s", group.DisplayName),
+		Description: fmt.Sprintf("Membership in the %s group", group.DisplayName),
+		GrantableTo: []*v2.ResourceType{userResourceType},
+		Slug:        membershipSlug,
+		Resource:    group,
+	}
+}
+
+// roleAssignedEntitlement is the direct mapping of a role resource.
+func roleAssignedEntitlement(role *v2.Resource) *v2.Entitlement {
+	return &v2.Entitlement{
+		Id:          roleAssignedEntitlementID(role.Id.Resource),
+		DisplayName: fmt.Sprintf("%s role", role.DisplayName),
+		Description: fmt.Sprintf("Assigned the %s role", role.DisplayName),
+		GrantableTo: []*v2.ResourceType{userResourceType, groupResourceType},
+		Slug:        assignedSlug,
+		Resource:    role,
+	}
+}
+
+// entitlementRef references an entitlement synced in full by another builder,
+// where only the ID of its resource is known.
+func entitlementRef(resourceType *v2.ResourceType, resourceID, slug string) *v2.Entitlement {
+	return &v2.Entitlement{
+		Id:       entitlementID(resourceType.Id, resourceID, slug),
+		Slug:     slug,
+		Resource: eventResource(resourceType, resourceID),
+	}
+}
+
+// newGrant grants a group membership or role entitlement to principal.
+func newGrant(entitlement *v2.Entitlement, principal *v2.Resource, annos ...proto.Message) *v2.Grant {
+	grant := &v2.Grant{
+		Id:          fmt.Sprintf("grant:%s:%s", entitlement.Resource.Id.Resource, principal.Id.Resource),
+		Entitlement: entitlement,
+		Principal:   principal,
+	}
+	// Optional annotations such as usage metadata may be typed nils.
+	annos = slices.DeleteFunc(annos, func(msg proto.Message) bool {
+		return msg == nil || !msg.ProtoReflect().IsValid()
+	})
+	if len(annos) > 0 {
+		grant.Annotations = annotations.New(annos...)
+	}
+	return grant
+}
+
+// memberExpansion passes a grant to a group or role on to the group's members
+// or the role's holders. It is nil for other principals.
+func memberExpansion(principal *v2.Resource) *v2.GrantExpandable {
+	switch principal.Id.ResourceType {
+	case groupResourceType.Id:
+		return &v2.GrantExpandable{EntitlementIds: []string{groupMembershipEntitlementID(principal.Id.Resource)}}
+	case roleResourceType.Id:
+		return &v2.GrantExpandable{EntitlementIds: []string{roleAssignedEntitlementID(principal.Id.Resource)}}
+	}
+	return nil
+}
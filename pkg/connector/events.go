@@ -0,0 +1,312 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultEventPageSize = 100
+	adminEventsFetchSize = 100
+)
+
+// feedResourceTypes are the admin event resource types converted into baton events.
+var feedResourceTypes = []string{
+	keycloak.AdminEventResourceUser,
+	keycloak.AdminEventResourceGroup,
+	keycloak.AdminEventResourceGroupMembership,
+	keycloak.AdminEventResourceRealmRoleMapping,
+	keycloak.AdminEventResourceClientRoleMapping,
+}
+
+// eventFeed holds the admin events read from Keycloak but not handed out yet,
+// so paging through a backlog reads it once rather than on every call.
+type eventFeed struct {
+	mu sync.Mutex
+	// after is the cursor the pending events follow.
+	after   string
+	pending []*keycloak.AdminEvent
+}
+
+func newEventFeed() *eventFeed {
+	return &eventFeed{}
+}
+
+// eventCursor is the time, in unix milliseconds, of the last event handed out
+// and the IDs of the events handed out at that time, so events recorded in the
+// same millisecond but read later are not lost or repeated.
+type eventCursor struct {
+	Time int64    `json:"time"`
+	IDs  []string `json:"ids,omitempty"`
+}
+
+// ListEvents turns Keycloak admin events into a baton event feed, oldest first.
+// Keycloak returns admin events newest first and only filters by day, so the
+// events since the cursor are read in full and handed out page by page.
+func (c *Connector) ListEvents(ctx context.Context, earliestEvent *timestamppb.Timestamp, pToken *pagination.StreamToken) ([]*v2.Event, *pagination.StreamState, annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	since, err := parseEventCursor(pToken, earliestEvent)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	pageSize := defaultEventPageSize
+	if pToken != nil && pToken.Size > 0 {
+		pageSize = pToken.Size
+	}
+
+	c.events.mu.Lock()
+	defer c.events.mu.Unlock()
+
+	pending := c.events.pending
+	if pToken == nil || pToken.Cursor == "" || pToken.Cursor != c.events.after || len(pending) == 0 {
+		pending, err = c.feedEventsSince(ctx, since)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	// Never split events recorded in the same millisecond across pages, the
+	// cursor holds the IDs handed out at its time instead.
+	end := min(pageSize, len(pending))
+	for end < len(pending) && pending[end].Time == pending[end-1].Time {
+		end++
+	}
+
+	cursor := since
+	events := make([]*v2.Event, 0, end)
+	for _, adminEvent := range pending[:end] {
+		if adminEvent.Time != cursor.Time {
+			cursor = eventCursor{Time: adminEvent.Time}
+		}
+		cursor.IDs = append(cursor.IDs, adminEventID(adminEvent))
+
+		event := adminEventToEvent(adminEvent)
+		if event == nil {
+			l.Debug("skipping admin event",
+				zap.String("resource_type", adminEvent.ResourceType),
+				zap.String("operation_type", adminEvent.OperationType),
+				zap.String("resource_path", adminEvent.ResourcePath),
+			)
+			continue
+		}
+		events = append(events, event)
+	}
+
+	encoded, err := json.Marshal(cursor)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	c.events.after = string(encoded)
+	c.events.pending = pending[end:]
+
+	return events, &pagination.StreamState{
+		Cursor:  c.events.after,
+		HasMore: end < len(pending),
+	}, nil, nil
+}
+
+// feedEventsSince returns the admin events of the feed recorded since the
+// cursor and not handed out yet, oldest first.
+func (c *Connector) feedEventsSince(ctx context.Context, since eventCursor) ([]*keycloak.AdminEvent, error) {
+	var from time.Time
+	if since.Time > 0 {
+		from = time.UnixMilli(since.Time)
+	}
+	events, err := adminEventsSince(ctx, c.client, from, feedResourceTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := slices.DeleteFunc(events, func(adminEvent *keycloak.AdminEvent) bool {
+		return adminEvent.Time == since.Time && slices.Contains(since.IDs, adminEventID(adminEvent))
+	})
+	slices.Reverse(pending)
+	return pending, nil
+}
+
+// adminEventsSince returns the successful admin events of resourceTypes
+// recorded since the given time, newest first. Keycloak pages newest first by
+// offset, so events recorded while paging push the ones already read onto the
+// next page. Events newer than the first page are left for the next read and
+// events read twice are dropped.
+func adminEventsSince(ctx context.Context, client keycloak.API, since time.Time, resourceTypes []string) ([]*keycloak.AdminEvent, error) {
+	params := keycloak.GetAdminEventsParams{
+		DateFrom:      since,
+		ResourceTypes: resourceTypes,
+		Max:           adminEventsFetchSize,
+	}
+
+	var (
+		events []*keycloak.AdminEvent
+		seen   = make(map[string]struct{})
+		until  int64
+	)
+	for {
+		page, err := client.GetAdminEvents(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		if params.First == 0 && len(page) > 0 {
+			until = page[0].Time
+		}
+
+		done := len(page) < adminEventsFetchSize
+		for _, adminEvent := range page {
+			if adminEvent.Time < since.UnixMilli() {
+				done = true
+				break
+			}
+			if adminEvent.Time > until {
+				continue
+			}
+			id := adminEventID(adminEvent)
+			if _, ok := seen[id]; ok {
+				continue
+			}
+			seen[id] = struct{}{}
+			if adminEvent.Error == "" {
+				events = append(events, adminEvent)
+			}
+		}
+
+		if done {
+			return events, nil
+		}
+		params.First += adminEventsFetchSize
+	}
+}
+
+// parseEventCursor reads the stream cursor. Cursors of earlier versions only
+// hold the time in unix milliseconds, with every event at that time handed out.
+func parseEventCursor(pToken *pagination.StreamToken, earliestEvent *timestamppb.Timestamp) (eventCursor, error) {
+	if pToken != nil && pToken.Cursor != "" {
+		if millis, err := strconv.ParseInt(pToken.Cursor, 10, 64); err == nil {
+			return eventCursor{Time: millis + 1}, nil
+		}
+		var cursor eventCursor
+		if err := json.Unmarshal([]byte(pToken.Cursor), &cursor); err != nil {
+			return eventCursor{}, fmt.Errorf("invalid event cursor %q: %w", pToken.Cursor, err)
+		}
+		return cursor, nil
+	}
+
+	if earliestEvent != nil {
+		return eventCursor{Time: earliestEvent.AsTime().UnixMilli()}, nil
+	}
+
+	return eventCursor{}, nil
+}
+
+// adminEventToEvent converts an admin event into a baton event, or nil when the
+// event has no baton equivalent.
+func adminEventToEvent(adminEvent *keycloak.AdminEvent) *v2.Event {
+	path := strings.Split(strings.Trim(adminEvent.ResourcePath, "/"), "/")
+	event := &v2.Event{
+		Id:         adminEventID(adminEvent),
+		OccurredAt: timestamppb.New(adminEvent.OccurredAt()),
+	}
+
+	switch adminEvent.ResourceType {
+	case keycloak.AdminEventResourceUser:
+		if len(path) < 2 || path[0] != "users" {
+			return nil
+		}
+		event.Event = resourceChangeEvent(userResourceType, path[1])
+
+	case keycloak.AdminEventResourceGroup:
+		if len(path) < 2 || path[0] != "groups" {
+			return nil
+		}
+		event.Event = resourceChangeEvent(groupResourceType, path[1])
+
+	case keycloak.AdminEventResourceGroupMembership:
+		// users/<userID>/groups/<groupID>
+		if len(path) != 4 || path[0] != "users" || path[2] != "groups" {
+			return nil
+		}
+		userID, groupID := path[1], path[3]
+
+		switch adminEvent.OperationType {
+		case keycloak.AdminEventOperationCreate:
+			event.Event = &v2.Event_GrantEvent{
+				GrantEvent: &v2.GrantEvent{
+					Grant: newGrant(entitlementRef(groupResourceType, groupID, membershipSlug), eventResource(userResourceType, userID)),
+				},
+			}
+		case keycloak.AdminEventOperationDelete:
+			event.Event = &v2.Event_RevokeEvent{
+				RevokeEvent: &v2.RevokeEvent{
+					Entitlement: entitlementRef(groupResourceType, groupID, membershipSlug),
+					Principal:   eventResource(userResourceType, userID),
+				},
+			}
+		default:
+			return nil
+		}
+
+	case keycloak.AdminEventResourceRealmRoleMapping, keycloak.AdminEventResourceClientRoleMapping:
+		// The mapped roles are only known when the realm records event
+		// representations, so have baton look at the user or group again.
+		if len(path) < 2 {
+			return nil
+		}
+		switch path[0] {
+		case "users":
+			event.Event = resourceChangeEvent(userResourceType, path[1])
+		case "groups":
+			event.Event = resourceChangeEvent(groupResourceType, path[1])
+		default:
+			return nil
+		}
+
+	default:
+		return nil
+	}
+
+	return event
+}
+
+// adminEventID prefers the ID newer Keycloak versions assign to admin events
+// and otherwise derives a stable one from the event contents.
+func adminEventID(adminEvent *keycloak.AdminEvent) string {
+	if adminEvent.ID != "" {
+		return adminEvent.ID
+	}
+	return fmt.Sprintf("%d:%s:%s:%s", adminEvent.Time, adminEvent.ResourceType, adminEvent.OperationType, adminEvent.ResourcePath)
+}
+
+func resourceChangeEvent(resourceType *v2.ResourceType, id string) *v2.Event_ResourceChangeEvent {
+	return &v2.Event_ResourceChangeEvent{
+		ResourceChangeEvent: &v2.ResourceChangeEvent{
+			ResourceId: &v2.ResourceId{
+				ResourceType: resourceType.Id,
+				Resource:     id,
+			},
+		},
+	}
+}
+
+func eventResource(resourceType *v2.ResourceType, id string) *v2.Resource {
+	return &v2.Resource{
+		Id: &v2.ResourceId{
+			ResourceType: resourceType.Id,
+			Resource:     id,
+		},
+	}
+}
@@ -0,0 +1,120 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak/fake"
+)
+
+// busyRealm records new admin events while the connector pages through them.
+type busyRealm struct {
+	*fake.Keycloak
+	reads int
+	// during is called after every read of admin events.
+	during func(reads int)
+}
+
+func (k *busyRealm) GetAdminEvents(ctx context.Context, params keycloak.GetAdminEventsParams) ([]*keycloak.AdminEvent, error) {
+	events, err := k.Keycloak.GetAdminEvents(ctx, params)
+	k.reads++
+	if k.during != nil {
+		k.during(k.reads)
+	}
+	return events, err
+}
+
+func userUpdated(n int, at time.Time) keycloak.AdminEvent {
+	return keycloak.AdminEvent{
+		ID:            fmt.Sprintf("event-%03d", n),
+		Time:          at.UnixMilli(),
+		OperationType: keycloak.AdminEventOperationUpdate,
+		ResourceType:  keycloak.AdminEventResourceUser,
+		ResourcePath:  fmt.Sprintf("users/user-%d", n),
+	}
+}
+
+func TestListEventsWhileEventsAreRecorded(t *testing.T) {
+	ctx := context.Background()
+	start := time.Now().Add(-time.Hour)
+	kc := &busyRealm{Keycloak: fake.New(testRealm)}
+	for n := range 150 {
+		kc.AddAdminEvent(userUpdated(n, start.Add(time.Duration(n)*time.Millisecond)))
+	}
+	// Newer events arrive between the first and the second page.
+	kc.during = func(reads int) {
+		if reads != 1 {
+			return
+		}
+		for n := 150; n < 180; n++ {
+			kc.AddAdminEvent(userUpdated(n, start.Add(time.Minute)))
+		}
+	}
+	c, err := newConnector(kc, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]int)
+	var order []string
+	listEvents := func(token *pagination.StreamToken) *pagination.StreamToken {
+		for {
+			events, state, _, err := c.ListEvents(ctx, nil, token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, event := range events {
+				seen[event.Id]++
+				order = append(order, event.Id)
+			}
+			token = &pagination.StreamToken{Size: 50, Cursor: state.Cursor}
+			if !state.HasMore {
+				return token
+			}
+		}
+	}
+
+	token := listEvents(&pagination.StreamToken{Size: 50})
+	if len(order) != 150 {
+		t.Fatalf("first listing handed out %d events, want the 150 recorded before it", len(order))
+	}
+	for n, id := range order {
+		if want := fmt.Sprintf("event-%03d", n); id != want {
+			t.Fatalf("event %d is %s, want %s", n, id, want)
+		}
+	}
+	if kc.reads != 2 {
+		t.Errorf("read admin events %d times for one backlog, want 2", kc.reads)
+	}
+
+	// Recorded in the millisecond the cursor stopped at.
+	kc.AddAdminEvent(userUpdated(180, start.Add(time.Minute)))
+	listEvents(token)
+	for n := range 181 {
+		if id := fmt.Sprintf("event-%03d", n); seen[id] != 1 {
+			t.Errorf("%s handed out %d times, want once", id, seen[id])
+		}
+	}
+}
+
+func TestListEventsLegacyCursor(t *testing.T) {
+	ctx := context.Background()
+	start := time.Now().Add(-time.Hour)
+	kc := fake.New(testRealm)
+	kc.AddAdminEvent(userUpdated(0, start))
+	kc.AddAdminEvent(userUpdated(1, start.Add(time.Millisecond)))
+
+	events, _, _, err := newTestConnector(kc).ListEvents(ctx, nil, &pagination.StreamToken{
+		Cursor: fmt.Sprint(start.UnixMilli()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Id != "event-001" {
+		t.Errorf("events after a cursor holding only a time: %v, want event-001", events)
+	}
+}
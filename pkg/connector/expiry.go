@@ -0,0 +1,205 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Keycloak has no attributes on group memberships, so the expiry of a
+// time-bound membership is kept on the user.
+const (
+	// grantExpiryAttribute holds one <group ID>=<RFC 3339 time> value per
+	// membership that ends at that time.
+	grantExpiryAttribute = "baton_grant_expiry"
+	// grantDurationAttribute on a group overrides the configured grant
+	// duration for its memberships, e.g. 8h. 0 makes them permanent.
+	grantDurationAttribute = "baton_grant_duration"
+)
+
+// grantExpiries returns the expiry of the user's time-bound memberships by
+// group ID. Values that don't parse are left out.
+func grantExpiries(user *gocloak.User) map[string]time.Time {
+	if user.Attributes == nil {
+		return make(map[string]time.Time)
+	}
+	return parseGrantExpiries((*user.Attributes)[grantExpiryAttribute])
+}
+
+func parseGrantExpiries(values []string) map[string]time.Time {
+	expiries := make(map[string]time.Time)
+	for _, value := range values {
+		groupID, at, ok := strings.Cut(value, "=")
+		if !ok {
+			continue
+		}
+		expiresAt, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			continue
+		}
+		expiries[groupID] = expiresAt
+	}
+	return expiries
+}
+
+func formatGrantExpiries(expiries map[string]time.Time) []string {
+	values := make([]string, 0, len(expiries))
+	for groupID, expiresAt := range expiries {
+		values = append(values, groupID+"="+expiresAt.UTC().Format(time.RFC3339))
+	}
+	slices.Sort(values)
+	return values
+}
+
+// grantDuration returns how long memberships of the group granted now last,
+// 0 for permanent ones.
+func (c *Connector) grantDuration(group *gocloak.Group) (time.Duration, error) {
+	if group.Attributes == nil {
+		return c.defaultGrantDuration, nil
+	}
+	values := (*group.Attributes)[grantDurationAttribute]
+	if len(values) == 0 {
+		return c.defaultGrantDuration, nil
+	}
+
+	duration, err := time.ParseDuration(values[0])
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("invalid %s %q on group %s", grantDurationAttribute, values[0], safeString(group.ID))
+	}
+	return duration, nil
+}
+
+// setGrantExpiry records when the user's membership of the group ends, or
+// clears it for a zero expiresAt. With the declarative user profile and
+// unmanaged attributes disabled, Keycloak drops the attribute without an error,
+// so the expiry is read back and a missing one fails the grant rather than
+// letting the membership become permanent.
+func (c *Connector) setGrantExpiry(ctx context.Context, user *gocloak.User, groupID string, expiresAt time.Time) error {
+	err := c.updateGrantExpiries(ctx, user, func(expiries map[string]time.Time) {
+		if expiresAt.IsZero() {
+			delete(expiries, groupID)
+		} else {
+			expiries[groupID] = expiresAt
+		}
+	})
+	if err != nil || expiresAt.IsZero() {
+		return err
+	}
+
+	stored, err := c.client.GetUser(ctx, safeString(user.ID))
+	if err != nil {
+		return fmt.Errorf("failed to read back grant expiry: %w", err)
+	}
+	if _, ok := grantExpiries(stored)[groupID]; !ok {
+		return status.Errorf(codes.FailedPrecondition,
+			"Keycloak did not store the %s attribute of user %s, allow unmanaged attributes in the realm's user profile",
+			grantExpiryAttribute, safeString(user.ID))
+	}
+	return nil
+}
+
+// updateGrantExpiries applies update to the user's expiries as Keycloak has
+// them now, not as the caller's copy of the user has them, so concurrent
+// grants and the reconciler don't drop each other's entries.
+func (c *Connector) updateGrantExpiries(ctx context.Context, user *gocloak.User, update func(expiries map[string]time.Time)) error {
+	var values []string
+	err := c.client.UpdateUserAttribute(ctx, safeString(user.ID), grantExpiryAttribute, func(current []string) []string {
+		expiries := parseGrantExpiries(current)
+		update(expiries)
+		values = formatGrantExpiries(expiries)
+		return values
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record grant expiry: %w", err)
+	}
+
+	// Keep the caller's copy in step with Keycloak.
+	attributes := make(map[string][]string)
+	if user.Attributes != nil {
+		for key, value := range *user.Attributes {
+			attributes[key] = value
+		}
+	}
+	if len(values) == 0 {
+		delete(attributes, grantExpiryAttribute)
+	} else {
+		attributes[grantExpiryAttribute] = values
+	}
+	user.Attributes = &attributes
+	return nil
+}
+
+// revokeExpiredGrants removes the users from the groups their time-bound
+// membership of has ended. It runs as users are listed, ahead of the grants,
+// so a sync never reports an expired membership even if ConductorOne never
+// sent the revoke. Only the users the sync scope includes are listed, so
+// memberships of users outside it are left until they are revoked.
+func (c *Connector) revokeExpiredGrants(ctx context.Context, users []*gocloak.User) error {
+	l := ctxzap.Extract(ctx)
+	now := time.Now()
+
+	for _, user := range users {
+		if !hasExpiredGrant(grantExpiries(user), now) {
+			continue
+		}
+
+		// The listed user may be stale, e.g. from the sync cache, and a grant
+		// since may have extended the membership.
+		fresh, err := c.client.GetUser(ctx, safeString(user.ID))
+		if err != nil {
+			if keycloak.IsNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("failed to get user: %w", err)
+		}
+
+		var expired []string
+		for groupID, expiresAt := range grantExpiries(fresh) {
+			if now.Before(expiresAt) {
+				continue
+			}
+
+			l.Info("Revoking expired group membership",
+				zap.String("user_id", safeString(user.ID)),
+				zap.String("group_id", groupID),
+				zap.Time("expired_at", expiresAt),
+			)
+			// A deleted group took the membership with it.
+			err := c.client.RemoveUserFromGroup(ctx, safeString(user.ID), groupID)
+			if err != nil && !keycloak.IsNotFound(err) {
+				return fmt.Errorf("failed to revoke expired membership of group %s: %w", groupID, err)
+			}
+			expired = append(expired, groupID)
+		}
+
+		err = c.updateGrantExpiries(ctx, user, func(expiries map[string]time.Time) {
+			for _, groupID := range expired {
+				if expiresAt, ok := expiries[groupID]; ok && !now.Before(expiresAt) {
+					delete(expiries, groupID)
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hasExpiredGrant(expiries map[string]time.Time, now time.Time) bool {
+	for _, expiresAt := range expiries {
+		if !now.Before(expiresAt) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,215 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak/fake"
+)
+
+func TestGrantRecordsExpiry(t *testing.T) {
+	ctx := context.Background()
+	kc := fake.New(testRealm)
+	alice := kc.AddUser(gocloak.User{Username: gocloak.StringP("alice")})
+	cluster := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("cluster-access")}, "")
+	admins := kc.AddGroup(gocloak.Group{
+		Name:       gocloak.StringP("admins"),
+		Attributes: &map[string][]string{grantDurationAttribute: {"0"}},
+	}, "")
+
+	c, err := newConnector(kc, Config{GrantDuration: 8 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := newGroupBuilder(c)
+	users := listAll(t, newUserBuilder(c), nil)
+
+	before := time.Now()
+	for _, groupID := range []string{cluster, admins} {
+		if _, _, err := builder.Grant(ctx, users[0], entitlementRef(groupResourceType, groupID, membershipSlug)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expiries := userGrantExpiries(t, kc, alice)
+	expiresAt, ok := expiries[cluster]
+	if !ok || expiresAt.Before(before.Add(8*time.Hour).Truncate(time.Second)) || expiresAt.After(time.Now().Add(8*time.Hour)) {
+		t.Errorf("cluster-access membership expires at %v, want 8h from now", expiresAt)
+	}
+	if _, ok := expiries[admins]; ok {
+		t.Error("admins membership got an expiry, its group makes it permanent")
+	}
+
+	grants, _, _, err := builder.Grants(ctx, listAll(t, builder, nil)[0], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := builder.Revoke(ctx, grants[0]); err != nil {
+		t.Fatal(err)
+	}
+	if expiries := userGrantExpiries(t, kc, alice); len(expiries) != 0 {
+		t.Errorf("expiries left after the revoke: %v", expiries)
+	}
+}
+
+func TestGrantFailsWhenExpiryIsDropped(t *testing.T) {
+	ctx := context.Background()
+	kc := fake.New(testRealm)
+	// The declarative user profile with unmanaged attributes disabled.
+	kc.ProfileAttributes = []string{}
+	alice := kc.AddUser(gocloak.User{Username: gocloak.StringP("alice")})
+	cluster := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("cluster-access")}, "")
+
+	c, err := newConnector(kc, Config{GrantDuration: 8 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := listAll(t, newUserBuilder(c), nil)
+	if _, _, err := newGroupBuilder(c).Grant(ctx, users[0], entitlementRef(groupResourceType, cluster, membershipSlug)); err == nil {
+		t.Error("Grant succeeded although Keycloak dropped the expiry")
+	}
+	if isMember(t, kc, alice, cluster) {
+		t.Error("alice was added to cluster-access without an expiry")
+	}
+}
+
+func TestUserListRevokesExpiredGrants(t *testing.T) {
+	ctx := context.Background()
+	kc := fake.New(testRealm)
+	admins := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("admins")}, "")
+	cluster := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("cluster-access")}, "")
+	later := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	alice := kc.AddUser(gocloak.User{
+		Username: gocloak.StringP("alice"),
+		Attributes: &map[string][]string{grantExpiryAttribute: {
+			admins + "=2020-01-01T00:00:00Z",
+			cluster + "=" + later,
+		}},
+	})
+	for _, groupID := range []string{admins, cluster} {
+		if err := kc.AddUserToGroup(ctx, alice, groupID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := newTestConnector(kc)
+	listAll(t, newUserBuilder(c), nil)
+
+	if isMember(t, kc, alice, admins) {
+		t.Error("alice is still a member of admins after it expired")
+	}
+	if !isMember(t, kc, alice, cluster) {
+		t.Error("alice lost cluster-access before it expired")
+	}
+
+	user, err := kc.GetUser(ctx, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := (*user.Attributes)[grantExpiryAttribute]; len(got) != 1 || got[0] != cluster+"="+later {
+		t.Errorf("%s after the revoke: %v", grantExpiryAttribute, got)
+	}
+}
+
+func TestConcurrentGrantsKeepEveryExpiry(t *testing.T) {
+	ctx := context.Background()
+	kc := fake.New(testRealm)
+	alice := kc.AddUser(gocloak.User{Username: gocloak.StringP("alice")})
+	var groupIDs []string
+	for i := range 10 {
+		groupIDs = append(groupIDs, kc.AddGroup(gocloak.Group{Name: gocloak.StringP(fmt.Sprintf("group-%d", i))}, ""))
+	}
+
+	srv := fake.NewServer(kc, e2eClientID, e2eClientSecret)
+	defer srv.Close()
+	client, err := keycloak.NewClient(keycloak.Config{
+		ServerURL:    srv.URL,
+		Realm:        testRealm,
+		ClientID:     e2eClientID,
+		ClientSecret: e2eClientSecret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newConnector(client, Config{GrantDuration: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := newGroupBuilder(c)
+	users := listAll(t, newUserBuilder(c), nil)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(groupIDs))
+	for _, groupID := range groupIDs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := builder.Grant(ctx, users[0], entitlementRef(groupResourceType, groupID, membershipSlug))
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expiries := userGrantExpiries(t, kc, alice)
+	for _, groupID := range groupIDs {
+		if _, ok := expiries[groupID]; !ok {
+			t.Errorf("expiry of the membership of %s was lost", groupID)
+		}
+	}
+}
+
+func TestRevokeExpiredGrantsRereadsUser(t *testing.T) {
+	ctx := context.Background()
+	kc := fake.New(testRealm)
+	admins := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("admins")}, "")
+	alice := kc.AddUser(gocloak.User{
+		Username:   gocloak.StringP("alice"),
+		Attributes: &map[string][]string{grantExpiryAttribute: {admins + "=2020-01-01T00:00:00Z"}},
+	})
+	if err := kc.AddUserToGroup(ctx, alice, admins); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := kc.GetUser(ctx, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A grant extends the membership after the user was listed.
+	c := newTestConnector(kc)
+	later := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := c.setGrantExpiry(ctx, stale, admins, later); err != nil {
+		t.Fatal(err)
+	}
+	stale.Attributes = &map[string][]string{grantExpiryAttribute: {admins + "=2020-01-01T00:00:00Z"}}
+
+	if err := c.revokeExpiredGrants(ctx, []*gocloak.User{stale}); err != nil {
+		t.Fatal(err)
+	}
+	if !isMember(t, kc, alice, admins) {
+		t.Error("alice lost admins although the membership was extended")
+	}
+	if expiresAt := userGrantExpiries(t, kc, alice)[admins]; !expiresAt.Equal(later) {
+		t.Errorf("admins membership expires at %v, want %v", expiresAt, later)
+	}
+}
+
+func userGrantExpiries(t *testing.T, kc *fake.Keycloak, userID string) map[string]time.Time {
+	t.Helper()
+
+	user, err := kc.GetUser(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return grantExpiries(user)
+}
@@ -0,0 +1,330 @@
+package connector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v13"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak/fake"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// TestGolden syncs a realm using every resource type and compares the
+// resources, entitlements and grants of each type with testdata/golden. Run
+// go test -run TestGolden -update after an intended change and review the diff.
+func TestGolden(t *testing.T) {
+	ctx := context.Background()
+	c := newTestConnector(goldenRealm())
+
+	syncers := c.ResourceSyncers(ctx)
+	byType := make(map[string]connectorbuilder.ResourceSyncer)
+	resources := make(map[string][]*v2.Resource)
+	for _, syncer := range syncers {
+		resourceType := syncer.ResourceType(ctx).Id
+		byType[resourceType] = syncer
+		resources[resourceType] = append(resources[resourceType], listAll(t, syncer, nil)...)
+	}
+	// Like the SDK's syncer, list the child types each client declares.
+	for _, client := range resources[clientResourceType.Id] {
+		for _, a := range client.Annotations {
+			child := &v2.ChildResourceType{}
+			if !a.MessageIs(child) {
+				continue
+			}
+			if err := a.UnmarshalTo(child); err != nil {
+				t.Fatal(err)
+			}
+			resources[child.ResourceTypeId] = append(resources[child.ResourceTypeId], listAll(t, byType[child.ResourceTypeId], client.Id)...)
+		}
+	}
+
+	for _, syncer := range syncers {
+		resourceType := syncer.ResourceType(ctx).Id
+		t.Run(resourceType, func(t *testing.T) {
+			var golden []goldenResource
+			for _, r := range resources[resourceType] {
+				golden = append(golden, describeResource(t, syncer, r))
+			}
+			compareGolden(t, resourceType, golden)
+		})
+	}
+}
+
+// goldenRealm seeds one of everything the connector syncs.
+func goldenRealm() *fake.Keycloak {
+	kc := fake.New(testRealm)
+	kc.UpdateRealm(func(realm *gocloak.RealmRepresentation) {
+		realm.EventsEnabled = gocloak.BoolP(true)
+	})
+
+	alice := kc.AddUser(gocloak.User{Username: gocloak.StringP("alice"), Email: gocloak.StringP("alice@example.com")})
+	bob := kc.AddUser(gocloak.User{Username: gocloak.StringP("bob"), Enabled: gocloak.BoolP(false)})
+
+	admins := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("admins")}, "")
+	kc.AddGroup(gocloak.Group{Name: gocloak.StringP("oncall")}, admins)
+	everyone := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("everyone")}, "")
+	kc.AddDefaultGroup(everyone)
+
+	offlineAccess := kc.AddRealmRole(gocloak.Role{Name: gocloak.StringP("offline_access")})
+	defaultRoles := kc.AddRealmRole(gocloak.Role{Name: gocloak.StringP("default-roles-test")})
+	kc.AddComposite(defaultRoles, offlineAccess)
+	kc.SetDefaultRole(defaultRoles)
+	auditor := kc.AddRealmRole(gocloak.Role{Name: gocloak.StringP("auditor"), Description: gocloak.StringP("Reads audit logs")})
+
+	billing := kc.AddClient(gocloak.Client{
+		ClientID:                     gocloak.StringP("billing"),
+		Name:                         gocloak.StringP("Billing"),
+		Protocol:                     gocloak.StringP("openid-connect"),
+		Enabled:                      gocloak.BoolP(true),
+		ServiceAccountsEnabled:       gocloak.BoolP(true),
+		AuthorizationServicesEnabled: gocloak.BoolP(true),
+		DefaultClientScopes:          &[]string{"profile"},
+		OptionalClientScopes:         &[]string{"billing-read"},
+	})
+	viewer := kc.AddClientRole(billing, gocloak.Role{Name: gocloak.StringP("viewer")})
+	serviceAccount := kc.AddUser(gocloak.User{Username: gocloak.StringP("service-account-billing")})
+	kc.SetServiceAccount(billing, serviceAccount)
+
+	realmManagement := kc.AddClient(gocloak.Client{
+		ClientID: gocloak.StringP(keycloak.RealmManagementClientID),
+		Protocol: gocloak.StringP("openid-connect"),
+		Enabled:  gocloak.BoolP(true),
+	})
+	kc.AddClientRole(realmManagement, gocloak.Role{Name: gocloak.StringP("realm-admin")})
+
+	for _, groupID := range []string{admins, everyone} {
+		_ = kc.AddUserToGroup(context.Background(), alice, groupID)
+	}
+	_ = kc.AddUserToGroup(context.Background(), bob, everyone)
+	kc.MapUserRole(bob, auditor)
+	kc.MapUserRole(alice, viewer)
+	kc.MapUserRole(serviceAccount, viewer)
+	kc.MapGroupRole(admins, auditor)
+
+	kc.AddClientScope(gocloak.ClientScope{Name: gocloak.StringP("profile"), Protocol: gocloak.StringP("openid-connect")}, "default")
+	billingRead := kc.AddClientScope(gocloak.ClientScope{Name: gocloak.StringP("billing-read"), Protocol: gocloak.StringP("openid-connect")}, "optional")
+	kc.MapClientScopeRole(billingRead, viewer)
+
+	kc.AddAuthzScope(billing, gocloak.ScopeRepresentation{Name: gocloak.StringP("read")})
+	kc.AddAuthzScope(billing, gocloak.ScopeRepresentation{Name: gocloak.StringP("write")})
+	invoices := kc.AddAuthzResource(billing, gocloak.ResourceRepresentation{
+		Name:   gocloak.StringP("invoices"),
+		Type:   gocloak.StringP("urn:billing:invoice"),
+		Scopes: &[]gocloak.ScopeRepresentation{{Name: gocloak.StringP("read")}, {Name: gocloak.StringP("write")}},
+	})
+	alicePolicy := kc.AddAuthzPolicy(gocloak.PolicyRepresentation{
+		Name:   gocloak.StringP("alice"),
+		Type:   gocloak.StringP("user"),
+		Config: &map[string]string{"users": fmt.Sprintf(`[%q]`, alice)},
+	})
+	adminsPolicy := kc.AddAuthzPolicy(gocloak.PolicyRepresentation{
+		Name:   gocloak.StringP("admins"),
+		Type:   gocloak.StringP("group"),
+		Config: &map[string]string{"groups": fmt.Sprintf(`[{"id":%q}]`, admins)},
+	})
+	kc.AddAuthzPermission(billing, gocloak.PermissionRepresentation{
+		Name: gocloak.StringP("read invoices"),
+		Type: gocloak.StringP("scope"),
+	}, nil, []string{"read"}, []string{adminsPolicy})
+	kc.AddAuthzPermission(billing, gocloak.PermissionRepresentation{
+		Name: gocloak.StringP("all on invoices"),
+		Type: gocloak.StringP("resource"),
+	}, []string{invoices}, nil, []string{alicePolicy})
+
+	auditorsPolicy := kc.AddAuthzPolicy(gocloak.PolicyRepresentation{
+		Name:   gocloak.StringP("auditors"),
+		Type:   gocloak.StringP("role"),
+		Config: &map[string]string{"roles": fmt.Sprintf(`[{"id":%q}]`, auditor)},
+	})
+	manageMembers := kc.AddAuthzPermission(realmManagement, gocloak.PermissionRepresentation{
+		Name: gocloak.StringP("manage.members.permission.group"),
+		Type: gocloak.StringP("scope"),
+	}, nil, nil, []string{auditorsPolicy})
+	kc.SetManagementPermissions(admins, map[string]string{"manage-members": manageMembers})
+
+	return kc
+}
+
+type goldenResource struct {
+	ID           string              `json:"id"`
+	DisplayName  string              `json:"display_name"`
+	Parent       string              `json:"parent,omitempty"`
+	Annotations  []string            `json:"annotations,omitempty"`
+	Entitlements []goldenEntitlement `json:"entitlements,omitempty"`
+	Grants       []goldenGrant       `json:"grants,omitempty"`
+}
+
+type goldenEntitlement struct {
+	ID          string   `json:"id"`
+	Slug        string   `json:"slug"`
+	DisplayName string   `json:"display_name"`
+	Description string   `json:"description"`
+	GrantableTo []string `json:"grantable_to"`
+	Annotations []string `json:"annotations,omitempty"`
+}
+
+type goldenGrant struct {
+	ID          string   `json:"id"`
+	Entitlement string   `json:"entitlement"`
+	Resource    string   `json:"resource"`
+	Principal   string   `json:"principal"`
+	Annotations []string `json:"annotations,omitempty"`
+}
+
+func describeResource(t *testing.T, syncer connectorbuilder.ResourceSyncer, r *v2.Resource) goldenResource {
+	t.Helper()
+	ctx := context.Background()
+
+	golden := goldenResource{
+		ID:          r.Id.Resource,
+		DisplayName: r.DisplayName,
+		Annotations: describeAnnotations(t, r.Annotations),
+	}
+	if r.ParentResourceId != nil {
+		golden.Parent = resourceKey(r.ParentResourceId)
+	}
+
+	token := ""
+	for {
+		entitlements, next, _, err := syncer.Entitlements(ctx, r, &pagination.Token{Token: token})
+		if err != nil {
+			t.Fatalf("Entitlements of %s: %v", resourceKey(r.Id), err)
+		}
+		for _, entitlement := range entitlements {
+			if entitlement.Resource.Id.Resource != r.Id.Resource {
+				t.Errorf("entitlement %s of %s is for resource %s", entitlement.Id, resourceKey(r.Id), resourceKey(entitlement.Resource.Id))
+			}
+
+			var grantableTo []string
+			for _, resourceType := range entitlement.GrantableTo {
+				grantableTo = append(grantableTo, resourceType.Id)
+			}
+			golden.Entitlements = append(golden.Entitlements, goldenEntitlement{
+				ID:          entitlement.Id,
+				Slug:        entitlement.Slug,
+				DisplayName: entitlement.DisplayName,
+				Description: entitlement.Description,
+				GrantableTo: grantableTo,
+				Annotations: describeAnnotations(t, entitlement.Annotations),
+			})
+		}
+		if token = next; token == "" {
+			break
+		}
+	}
+
+	for {
+		grants, next, _, err := syncer.Grants(ctx, r, &pagination.Token{Token: token})
+		if err != nil {
+			t.Fatalf("Grants of %s: %v", resourceKey(r.Id), err)
+		}
+		for _, grant := range grants {
+			golden.Grants = append(golden.Grants, goldenGrant{
+				ID:          grant.Id,
+				Entitlement: grant.Entitlement.Id,
+				Resource:    resourceKey(grant.Entitlement.Resource.Id),
+				Principal:   resourceKey(grant.Principal.Id),
+				Annotations: describeAnnotations(t, grant.Annotations),
+			})
+		}
+		if token = next; token == "" {
+			break
+		}
+	}
+
+	return golden
+}
+
+// describeAnnotations renders annotations as their message name followed by
+// the fields worth locking down.
+func describeAnnotations(t *testing.T, annos []*anypb.Any) []string {
+	t.Helper()
+
+	var described []string
+	for _, a := range annos {
+		msg, err := a.UnmarshalNew()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		name := string(proto.MessageName(msg).Name())
+		switch m := msg.(type) {
+		case *v2.UserTrait:
+			name = fmt.Sprintf("%s %s %s %s", name, m.GetStatus().GetStatus(), m.GetAccountType(), structJSON(t, m.GetProfile()))
+		case *v2.GroupTrait:
+			name = fmt.Sprintf("%s %s", name, structJSON(t, m.GetProfile()))
+		case *v2.RoleTrait:
+			name = fmt.Sprintf("%s %s", name, structJSON(t, m.GetProfile()))
+		case *v2.AppTrait:
+			name = fmt.Sprintf("%s %s", name, structJSON(t, m.GetProfile()))
+		case *v2.ChildResourceType:
+			name = fmt.Sprintf("%s %s", name, m.GetResourceTypeId())
+		case *v2.GrantExpandable:
+			name = fmt.Sprintf("%s %s", name, strings.Join(m.GetEntitlementIds(), ","))
+		case *v2.GrantMetadata:
+			name = fmt.Sprintf("%s %s", name, structJSON(t, m.GetMetadata()))
+		}
+		described = append(described, name)
+	}
+	return described
+}
+
+// structJSON encodes a struct with sorted keys, unlike protojson whose output
+// is deliberately unstable.
+func structJSON(t *testing.T, s *structpb.Struct) string {
+	t.Helper()
+
+	encoded, err := json.Marshal(s.AsMap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(encoded)
+}
+
+func resourceKey(id *v2.ResourceId) string {
+	return id.ResourceType + ":" + id.Resource
+}
+
+func compareGolden(t *testing.T, name string, v any) {
+	t.Helper()
+
+	got, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, '\n')
+
+	path := filepath.Join("testdata", "golden", name+".json")
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v, run go test -run TestGolden -update to create it", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from %s, run go test -run TestGolden -update and review the diff:\n%s", name, path, got)
+	}
+}
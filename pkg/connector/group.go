@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/Nerzal/gocloak/v13"
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
@@ -11,8 +12,11 @@ import (
 	"github.com/conductorone/baton-sdk/pkg/pagination"
 	"github.com/conductorone/baton-sdk/pkg/types/resource"
 	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
 	"github.com/spiros-spiros/baton-keycloak/pkg/utils"
 	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type groupBuilder struct {
@@ -28,13 +32,26 @@ func (o *groupBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId
 	var resources []*v2.Resource
 	annos := annotations.Annotations{}
 
-	groups, nextToken, err := o.client.client.GetGroups(ctx, utils.ParseToken(pToken))
+	var (
+		groups    []*gocloak.Group
+		nextToken string
+		err       error
+	)
+	if o.client.cache != nil {
+		groups, nextToken, err = o.client.cache.Groups(ctx, utils.ParseToken(pToken))
+	} else {
+		groups, nextToken, err = o.client.client.GetGroups(ctx, utils.ParseToken(pToken))
+	}
 	if err != nil {
 		return nil, "", nil, err
 	}
 
 	for _, group := range groups {
-		groupResource, err := parseIntoGroupResource(group, nil)
+		if !o.client.scope.includesGroup(group) {
+			continue
+		}
+
+		groupResource, err := o.client.groupResource(group)
 		if err != nil {
 			return nil, "", nil, err
 		}
@@ -45,19 +62,18 @@ func (o *groupBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId
 }
 
 func (o *groupBuilder) Entitlements(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
-	var entitlements []*v2.Entitlement
+	membership := groupMembershipEntitlement(resource)
+	if err := o.client.hideGroupEntitlement(ctx, membership); err != nil {
+		return nil, "", nil, err
+	}
+	entitlements := []*v2.Entitlement{membership}
 
-	// Create a membership entitlement for the group
-	membershipEntitlement := &v2.Entitlement{
-		Id:          fmt.Sprintf("group:%s:membership", resource.Id.Resource),
-		DisplayName: fmt.Sprintf("Membership in %s", resource.DisplayName),
-		Description: fmt.Sprintf("Membership in the %s group", resource.DisplayName),
-		GrantableTo: []*v2.ResourceType{userResourceType},
-		Slug:        "membership",
-		Resource:    resource,
+	permissions, err := o.client.client.GetGroupManagementPermissions(ctx, resource.Id.Resource)
+	if err != nil {
+		return nil, "", nil, err
 	}
+	entitlements = append(entitlements, managementEntitlements(resource, permissions)...)
 
-	entitlements = append(entitlements, membershipEntitlement)
 	return entitlements, "", nil, nil
 }
 
@@ -70,6 +86,7 @@ func (o *groupBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken
 	if err != nil {
 		return nil, "", nil, err
 	}
+	users = o.client.scope.filterUsers(users)
 
 	// Create a map of user IDs to their resources for quick lookup
 	userResources := make(map[string]*v2.Resource)
@@ -81,24 +98,27 @@ func (o *groupBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken
 		userResources[*user.ID] = userResource
 	}
 
+	// Every new user joins the realm's default groups without asking for it.
+	defaultGroup, err := o.client.defaults.isDefaultGroup(ctx, resource.Id.Resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	membership := groupMembershipEntitlement(resource)
 	for _, user := range users {
-		userResource := userResources[*user.ID]
-
-		grant := &v2.Grant{
-			Id: fmt.Sprintf("grant:%s:%s", resource.Id.Resource, *user.ID),
-			Entitlement: &v2.Entitlement{
-				Id:          fmt.Sprintf("group:%s:membership", resource.Id.Resource),
-				DisplayName: fmt.Sprintf("Membership in %s", resource.DisplayName),
-				Description: fmt.Sprintf("Membership in the %s group", resource.DisplayName),
-				GrantableTo: []*v2.ResourceType{userResourceType},
-				Slug:        "membership",
-				Resource:    resource,
-			},
-			Principal: userResource,
-		}
+		grants = append(grants, o.membershipGrant(ctx, membership, userResources[*user.ID], defaultGroup))
+	}
 
-		grants = append(grants, grant)
+	// Delegated admins of the group
+	permissions, err := o.client.client.GetGroupManagementPermissions(ctx, resource.Id.Resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	managementGrants, err := o.client.managementGrants(ctx, resource, permissions)
+	if err != nil {
+		return nil, "", nil, err
 	}
+	grants = append(grants, managementGrants...)
 
 	return grants, "", annos, nil
 }
@@ -111,24 +131,81 @@ func (o *groupBuilder) Grant(ctx context.Context, resource *v2.Resource, entitle
 		zap.String("entitlement_id", entitlement.Id),
 	)
 
-	// The entitlement ID should be in the format: group:<groupID>:membership
-	parts := strings.Split(entitlement.Id, ":")
-	l.Info("Split entitlement ID parts", zap.Strings("parts", parts))
-	if len(parts) != 3 || parts[0] != "group" || parts[2] != "membership" {
+	groupID, err := parseEntitlementID(entitlement.Id, groupResourceType, membershipSlug)
+	if err != nil {
 		l.Error("Invalid entitlement ID format")
-		return nil, nil, fmt.Errorf("invalid entitlement ID format: %s", entitlement.Id)
-	}
-
-	// Get the group ID from the entitlement ID
-	groupID := parts[1]
-	if groupID == "" {
-		l.Error("Group ID not found in entitlement ID")
-		return nil, nil, fmt.Errorf("group ID not found in entitlement ID")
+		return nil, nil, err
 	}
 	l.Info("Extracted group ID", zap.String("group_id", groupID))
 
 	userID := resource.Id.Resource
 
+	// resource is the user being granted, the group comes from the entitlement.
+	// Both are looked up so the returned grant matches the next sync.
+	group, groupResource, err := o.lookupGroup(ctx, groupID)
+	if err != nil {
+		return nil, nil, err
+	}
+	groupID = safeString(group.ID)
+	if err := o.checkProtected(ctx, group); err != nil {
+		return nil, nil, err
+	}
+	user, userResource, err := o.lookupUser(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	duration, err := o.client.grantDuration(group)
+	if err != nil {
+		return nil, nil, err
+	}
+	var expiresAt time.Time
+	if duration > 0 {
+		expiresAt = time.Now().Add(duration)
+	}
+
+	defaultGroup, err := o.client.defaults.isDefaultGroup(ctx, groupID)
+	if err != nil {
+		return nil, nil, err
+	}
+	grant := o.membershipGrant(ctx, groupMembershipEntitlement(groupResource), userResource, defaultGroup)
+
+	member, err := o.isMember(ctx, userID, groupID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if member {
+		l.Info("User is already a member of the group",
+			zap.String("user_id", userID),
+			zap.String("group_id", groupID),
+		)
+		// A time-bound membership is extended or made permanent, a permanent
+		// one is never shortened.
+		err := o.client.updateGrantExpiries(ctx, user, func(expiries map[string]time.Time) {
+			current, ok := expiries[groupID]
+			switch {
+			case !ok:
+			case expiresAt.IsZero():
+				delete(expiries, groupID)
+			case expiresAt.After(current):
+				expiries[groupID] = expiresAt
+			}
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return []*v2.Grant{grant}, annotations.New(&v2.GrantAlreadyExists{}), nil
+	}
+
+	// The expiry is recorded first, so a membership is never added without it.
+	// This also drops one left over from an earlier membership.
+	if err := o.client.setGrantExpiry(ctx, user, groupID, expiresAt); err != nil {
+		return nil, nil, err
+	}
+	if !expiresAt.IsZero() {
+		l.Info("Membership is time-bound", zap.Time("expires_at", expiresAt))
+	}
+
 	// Add user to group
 	l.Info("Attempting to add user to group",
 		zap.String("user_id", userID),
@@ -137,29 +214,9 @@ func (o *groupBuilder) Grant(ctx context.Context, resource *v2.Resource, entitle
 
 	if err := o.client.client.AddUserToGroup(ctx, userID, groupID); err != nil {
 		l.Error("Failed to add user to group", zap.Error(err))
-		return nil, nil, fmt.Errorf("failed to add user to group: %w", err)
-	}
-	l.Info("Successfully added user to group")
-
-	// Create and return the grant
-	grant := &v2.Grant{
-		Id: fmt.Sprintf("grant:%s:%s", groupID, userID),
-		Entitlement: &v2.Entitlement{
-			Id:          fmt.Sprintf("group:%s:membership", groupID),
-			DisplayName: fmt.Sprintf("Membership in %s", resource.DisplayName),
-			Description: fmt.Sprintf("Membership in the %s group", resource.DisplayName),
-			GrantableTo: []*v2.ResourceType{userResourceType},
-			Slug:        "membership",
-			Resource:    resource,
-		},
-		Principal: &v2.Resource{
-			Id: &v2.ResourceId{
-				ResourceType: userResourceType.Id,
-				Resource:     userID,
-			},
-		},
-	}
-	l.Info("Created grant", zap.String("grant_id", grant.Id))
+		return nil, nil, membershipError(err, "failed to add user to group", userID, groupID)
+	}
+	l.Info("Successfully added user to group", zap.String("grant_id", grant.Id))
 
 	return []*v2.Grant{grant}, nil, nil
 }
@@ -171,22 +228,44 @@ func (o *groupBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations
 		zap.String("entitlement_id", grant.Entitlement.Id),
 	)
 
-	// Extract group ID from the entitlement ID
-	parts := strings.Split(grant.Entitlement.Id, ":")
-	if len(parts) != 3 || parts[0] != "group" || parts[2] != "membership" {
+	groupID, err := parseEntitlementID(grant.Entitlement.Id, groupResourceType, membershipSlug)
+	if err != nil {
 		l.Error("Invalid entitlement ID format")
-		return nil, fmt.Errorf("invalid entitlement ID format: %s", grant.Entitlement.Id)
-	}
-
-	groupID := parts[1]
-	if groupID == "" {
-		l.Error("Group ID not found in entitlement ID")
-		return nil, fmt.Errorf("group ID not found in entitlement ID")
+		return nil, err
 	}
 	l.Info("Extracted group ID", zap.String("group_id", groupID))
 
 	userID := grant.Principal.Id.Resource
 
+	group, _, err := o.lookupGroup(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	groupID = safeString(group.ID)
+	if err := o.checkProtected(ctx, group); err != nil {
+		return nil, err
+	}
+	user, _, err := o.lookupUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	member, err := o.isMember(ctx, userID, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The reconciler has nothing left to revoke either way.
+	if err := o.client.setGrantExpiry(ctx, user, groupID, time.Time{}); err != nil {
+		return nil, err
+	}
+	if !member {
+		l.Info("User is not a member of the group",
+			zap.String("user_id", userID),
+			zap.String("group_id", groupID),
+		)
+		return annotations.New(&v2.GrantAlreadyRevoked{}), nil
+	}
+
 	// Remove user from group
 	l.Info("Attempting to remove user from group",
 		zap.String("user_id", userID),
@@ -195,17 +274,145 @@ func (o *groupBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations
 
 	if err := o.client.client.RemoveUserFromGroup(ctx, userID, groupID); err != nil {
 		l.Error("Failed to remove user from group", zap.Error(err))
-		return nil, fmt.Errorf("failed to remove user from group: %w", err)
+		return nil, membershipError(err, "failed to remove user from group", userID, groupID)
 	}
 	l.Info("Successfully removed user from group")
 
 	return nil, nil
 }
 
-func parseIntoGroupResource(group *gocloak.Group, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
+// checkProtected refuses provisioning of a protected group, or one whose
+// members receive a protected role.
+func (o *groupBuilder) checkProtected(ctx context.Context, group *gocloak.Group) error {
+	l := ctxzap.Extract(ctx)
+	err := o.client.protection.checkGroup(group)
+	if err == nil {
+		err = o.client.checkGroupRoles(ctx, group)
+	}
+	if err != nil {
+		l.Warn("Refusing to provision a protected group", zap.String("group_id", safeString(group.ID)), zap.Error(err))
+	}
+	return err
+}
+
+// lookupGroup returns the group and its resource as the sync lists it. The
+// group is given by ID, or by its full path when it starts with a slash, so
+// provisioning requests can name groups the way admins know them.
+func (o *groupBuilder) lookupGroup(ctx context.Context, groupRef string) (*gocloak.Group, *v2.Resource, error) {
+	var (
+		group *gocloak.Group
+		err   error
+	)
+	if strings.HasPrefix(groupRef, "/") {
+		group, err = o.client.client.GetGroupByPath(ctx, groupRef)
+	} else {
+		group, err = o.client.client.GetGroup(ctx, groupRef)
+	}
+	if err != nil {
+		if keycloak.IsNotFound(err) {
+			return nil, nil, status.Errorf(codes.NotFound, "group %s not found", groupRef)
+		}
+		return nil, nil, fmt.Errorf("failed to get group: %w", err)
+	}
+	groupResource, err := o.client.groupResource(group)
+	if err != nil {
+		return nil, nil, err
+	}
+	return group, groupResource, nil
+}
+
+// lookupUser returns the user and its resource as group grants list it.
+func (o *groupBuilder) lookupUser(ctx context.Context, userID string) (*gocloak.User, *v2.Resource, error) {
+	user, err := o.client.client.GetUser(ctx, userID)
+	if err != nil {
+		if keycloak.IsNotFound(err) {
+			return nil, nil, status.Errorf(codes.NotFound, "user %s not found", userID)
+		}
+		return nil, nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	userResource, err := parseIntoUserResource(user, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, userResource, nil
+}
+
+// isMember reports whether the user is a direct member of the group.
+func (o *groupBuilder) isMember(ctx context.Context, userID, groupID string) (bool, error) {
+	groups, err := o.client.client.GetUserGroups(ctx, userID)
+	if err != nil {
+		if keycloak.IsNotFound(err) {
+			return false, status.Errorf(codes.NotFound, "user %s not found", userID)
+		}
+		return false, fmt.Errorf("failed to get groups of user: %w", err)
+	}
+	for _, group := range groups {
+		if safeString(group.ID) == groupID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// membershipError keeps a 404 from a membership change, which means the user
+// or group was deleted after the checks, recognisable as not found.
+func membershipError(err error, msg, userID, groupID string) error {
+	if keycloak.IsNotFound(err) {
+		return status.Errorf(codes.NotFound, "%s: user %s or group %s not found", msg, userID, groupID)
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
+
+// membershipGrant is a user's membership grant as the group's Grants lists it.
+func (o *groupBuilder) membershipGrant(ctx context.Context, membership *v2.Entitlement, userResource *v2.Resource, defaultGroup bool) *v2.Grant {
+	// Group membership is used through logins, so it shares the user's last login.
+	metadata := usageGrantMetadata(o.client.usage.LastLogin(ctx, userResource.Id.Resource))
+	if defaultGroup {
+		metadata = markDefault(metadata, "default_group")
+	}
+
+	return newGrant(membership, userResource, metadata)
+}
+
+// The ways groups can be displayed, see Config.GroupDisplayName.
+const (
+	GroupDisplayNameName = "name"
+	GroupDisplayNamePath = "path"
+)
+
+// groupDisplayName returns the name the group is displayed by.
+func (c *Connector) groupDisplayName(group *gocloak.Group) string {
+	if c.groupsByPath && safeString(group.Path) != "" {
+		return safeString(group.Path)
+	}
+	return safeString(group.Name)
+}
+
+// groupResource converts a group into its resource as the sync lists it.
+func (c *Connector) groupResource(group *gocloak.Group) (*v2.Resource, error) {
+	return parseIntoGroupResource(group, nil, c.groupDisplayName(group))
+}
+
+func parseIntoGroupResource(group *gocloak.Group, parentResourceID *v2.ResourceId, displayName string) (*v2.Resource, error) {
+	path := safeString(group.Path)
 	profile := map[string]interface{}{
 		"name": safeString(group.Name),
-		"path": safeString(group.Path),
+		"path": path,
+	}
+
+	// The paths of the group's ancestors and its own, top-level group first,
+	// so groups can be filtered by any of their parents.
+	var hierarchy []interface{}
+	for i := 1; i < len(path); i++ {
+		if path[i] == '/' {
+			hierarchy = append(hierarchy, path[:i])
+		}
+	}
+	if len(hierarchy) > 0 {
+		profile["parent_path"] = hierarchy[len(hierarchy)-1]
+	}
+	if path != "" {
+		profile["path_hierarchy"] = append(hierarchy, path)
 	}
 
 	if group.Attributes != nil {
@@ -219,7 +426,7 @@ func parseIntoGroupResource(group *gocloak.Group, parentResourceID *v2.ResourceI
 	}
 
 	ret, err := resource.NewGroupResource(
-		safeString(group.Name),
+		displayName,
 		groupResourceType,
 		*group.ID,
 		groupTraits,
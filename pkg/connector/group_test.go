@@ -0,0 +1,358 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v13"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/google/go-cmp/cmp"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak/fake"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+func TestGroupListTopLevel(t *testing.T) {
+	kc := fake.New(testRealm)
+	kc.PageSize = 1
+	engineering := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("engineering")}, "")
+	kc.AddGroup(gocloak.Group{Name: gocloak.StringP("platform")}, engineering)
+	sales := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("sales")}, "")
+
+	groups := listAll(t, newGroupBuilder(newTestConnector(kc)), nil)
+
+	if got, want := resourceIDs(groups), []string{engineering, sales}; !slices.Equal(got, want) {
+		t.Fatalf("listed groups %v, want %v", got, want)
+	}
+}
+
+func TestGroupGrantsMembers(t *testing.T) {
+	ctx := context.Background()
+	kc := fake.New(testRealm)
+	alice := kc.AddUser(gocloak.User{Username: gocloak.StringP("alice")})
+	kc.AddUser(gocloak.User{Username: gocloak.StringP("bob")})
+	carol := kc.AddUser(gocloak.User{Username: gocloak.StringP("carol")})
+	admins := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("admins")}, "")
+	for _, userID := range []string{alice, carol} {
+		if err := kc.AddUserToGroup(ctx, userID, admins); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	builder := newGroupBuilder(newTestConnector(kc))
+	groups := listAll(t, builder, nil)
+
+	grants, _, _, err := builder.Grants(ctx, groups[0], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := principalIDs(grants), []string{alice, carol}; !slices.Equal(got, want) {
+		t.Fatalf("granted to %v, want %v", got, want)
+	}
+	for _, grant := range grants {
+		if want := fmt.Sprintf("group:%s:membership", admins); grant.Entitlement.Id != want {
+			t.Errorf("grant %s is for entitlement %s, want %s", grant.Id, grant.Entitlement.Id, want)
+		}
+	}
+}
+
+func TestGroupGrantAndRevokeMembership(t *testing.T) {
+	ctx := context.Background()
+	kc := fake.New(testRealm)
+	alice := kc.AddUser(gocloak.User{Username: gocloak.StringP("alice")})
+	admins := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("admins")}, "")
+
+	c := newTestConnector(kc)
+	builder := newGroupBuilder(c)
+	users := listAll(t, newUserBuilder(c), nil)
+	groups := listAll(t, builder, nil)
+
+	entitlements, _, _, err := builder.Entitlements(ctx, groups[0], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	membership := entitlements[0]
+
+	grants, _, err := builder.Grant(ctx, users[0], membership)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grants) != 1 {
+		t.Fatalf("Grant returned %d grants, want 1", len(grants))
+	}
+
+	members, err := kc.GetGroupMembers(ctx, admins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || *members[0].ID != alice {
+		t.Fatalf("members after Grant: %v", members)
+	}
+
+	synced, _, _, err := builder.Grants(ctx, groups[0], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(synced) != 1 {
+		t.Fatalf("the next sync lists %d grants, want 1", len(synced))
+	}
+	if diff := cmp.Diff(synced[0], grants[0], protocmp.Transform()); diff != "" {
+		t.Fatalf("Grant returned a different grant than the next sync lists (-synced +granted):\n%s", diff)
+	}
+
+	if _, err := builder.Revoke(ctx, grants[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	members, err = kc.GetGroupMembers(ctx, admins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 0 {
+		t.Fatalf("%d members left after Revoke", len(members))
+	}
+}
+
+func TestGroupGrantMissing(t *testing.T) {
+	ctx := context.Background()
+	kc := fake.New(testRealm)
+	kc.AddUser(gocloak.User{Username: gocloak.StringP("alice")})
+	kc.AddGroup(gocloak.Group{Name: gocloak.StringP("admins")}, "")
+
+	c := newTestConnector(kc)
+	builder := newGroupBuilder(c)
+	users := listAll(t, newUserBuilder(c), nil)
+	groups := listAll(t, builder, nil)
+
+	deletedGroup := entitlementRef(groupResourceType, "deleted", membershipSlug)
+	_, _, err := builder.Grant(ctx, users[0], deletedGroup)
+	if status.Code(err) != codes.NotFound || !strings.Contains(err.Error(), "group deleted not found") {
+		t.Errorf("Grant of a deleted group: %v", err)
+	}
+	_, err = builder.Revoke(ctx, newGrant(deletedGroup, users[0]))
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Revoke of a deleted group: %v", err)
+	}
+
+	deletedUser := eventResource(userResourceType, "deleted")
+	membership := groupMembershipEntitlement(groups[0])
+	_, _, err = builder.Grant(ctx, deletedUser, membership)
+	if status.Code(err) != codes.NotFound || !strings.Contains(err.Error(), "user deleted not found") {
+		t.Errorf("Grant to a deleted user: %v", err)
+	}
+	_, err = builder.Revoke(ctx, newGrant(membership, deletedUser))
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Revoke from a deleted user: %v", err)
+	}
+}
+
+func TestGroupGrantAndRevokeIdempotent(t *testing.T) {
+	ctx := context.Background()
+	kc := fake.New(testRealm)
+	alice := kc.AddUser(gocloak.User{Username: gocloak.StringP("alice")})
+	admins := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("admins")}, "")
+	if err := kc.AddUserToGroup(ctx, alice, admins); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestConnector(kc)
+	builder := newGroupBuilder(c)
+	users := listAll(t, newUserBuilder(c), nil)
+	groups := listAll(t, builder, nil)
+	membership := groupMembershipEntitlement(groups[0])
+
+	grants, annos, err := builder.Grant(ctx, users[0], membership)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grants) != 1 || !annos.Contains(&v2.GrantAlreadyExists{}) {
+		t.Errorf("Grant of an existing membership returned %v with %v", grants, annos)
+	}
+
+	if _, err := builder.Revoke(ctx, grants[0]); err != nil {
+		t.Fatal(err)
+	}
+	annos, err = builder.Revoke(ctx, grants[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !annos.Contains(&v2.GrantAlreadyRevoked{}) {
+		t.Errorf("Revoke of a removed membership returned %v", annos)
+	}
+	if isMember(t, kc, alice, admins) {
+		t.Error("alice is still a member of admins")
+	}
+}
+
+func TestGroupDisplayNameAndHierarchy(t *testing.T) {
+	ctx := context.Background()
+	kc := fake.New(testRealm)
+	engineering := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("engineering")}, "")
+	platform := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("platform")}, engineering)
+	sales := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("sales")}, "")
+	var groups []*gocloak.Group
+	for _, parentID := range []string{platform, sales} {
+		group, err := kc.GetGroup(ctx, kc.AddGroup(gocloak.Group{Name: gocloak.StringP("admins")}, parentID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		groups = append(groups, group)
+	}
+
+	for displayName, want := range map[string][]string{
+		"":                   {"admins", "admins"},
+		GroupDisplayNameName: {"admins", "admins"},
+		GroupDisplayNamePath: {"/engineering/platform/admins", "/sales/admins"},
+	} {
+		c, err := newConnector(kc, Config{GroupDisplayName: displayName})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, group := range groups {
+			groupResource, err := c.groupResource(group)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, groupResource.DisplayName)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("groups displayed by %q: %v, want %v", displayName, got, want)
+		}
+	}
+
+	groupResource, err := newTestConnector(kc).groupResource(groups[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	trait, err := resource.GetGroupTrait(groupResource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent, _ := resource.GetProfileStringValue(trait.Profile, "parent_path"); parent != "/engineering/platform" {
+		t.Errorf("parent_path %q, want /engineering/platform", parent)
+	}
+	var hierarchy []string
+	for _, value := range trait.Profile.Fields["path_hierarchy"].GetListValue().GetValues() {
+		hierarchy = append(hierarchy, value.GetStringValue())
+	}
+	if want := []string{"/engineering", "/engineering/platform", "/engineering/platform/admins"}; !slices.Equal(hierarchy, want) {
+		t.Errorf("path_hierarchy %v, want %v", hierarchy, want)
+	}
+}
+
+func TestGroupGrantByPath(t *testing.T) {
+	ctx := context.Background()
+	kc := fake.New(testRealm)
+	alice := kc.AddUser(gocloak.User{Username: gocloak.StringP("alice")})
+	engineering := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("engineering")}, "")
+	admins := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("admins")}, engineering)
+
+	srv := fake.NewServer(kc, e2eClientID, e2eClientSecret)
+	defer srv.Close()
+	client, err := keycloak.NewClient(keycloak.Config{
+		ServerURL:    srv.URL,
+		Realm:        testRealm,
+		ClientID:     e2eClientID,
+		ClientSecret: e2eClientSecret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newConnector(client, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := newGroupBuilder(c)
+	users := listAll(t, newUserBuilder(c), nil)
+
+	byPath := entitlementRef(groupResourceType, "/engineering/admins", membershipSlug)
+	grants, _, err := builder.Grant(ctx, users[0], byPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isMember(t, kc, alice, admins) {
+		t.Fatal("alice is not a member of /engineering/admins after the grant")
+	}
+	if got, want := grants[0].Entitlement.Id, groupMembershipEntitlementID(admins); got != want {
+		t.Errorf("granted entitlement %s, want %s", got, want)
+	}
+
+	if _, err := builder.Revoke(ctx, newGrant(byPath, users[0])); err != nil {
+		t.Fatal(err)
+	}
+	if isMember(t, kc, alice, admins) {
+		t.Error("alice is still a member of /engineering/admins after the revoke")
+	}
+
+	missing := entitlementRef(groupResourceType, "/engineering/missing", membershipSlug)
+	if _, _, err := builder.Grant(ctx, users[0], missing); status.Code(err) != codes.NotFound {
+		t.Errorf("Grant of a missing path: %v", err)
+	}
+}
+
+func TestInvalidGroupDisplayName(t *testing.T) {
+	if _, err := newConnector(fake.New(testRealm), Config{GroupDisplayName: "id"}); err == nil {
+		t.Error("newConnector accepted an unknown group display name")
+	}
+}
+
+func TestGroupGrantAndRevokeForUserInManyGroups(t *testing.T) {
+	ctx := context.Background()
+	kc := fake.New(testRealm)
+	alice := kc.AddUser(gocloak.User{Username: gocloak.StringP("alice")})
+	// More groups than Keycloak returns in one page by default.
+	var last string
+	for i := range 150 {
+		last = kc.AddGroup(gocloak.Group{Name: gocloak.StringP(fmt.Sprintf("team-%03d", i))}, "")
+		if err := kc.AddUserToGroup(ctx, alice, last); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv := fake.NewServer(kc, e2eClientID, e2eClientSecret)
+	defer srv.Close()
+	client, err := keycloak.NewClient(keycloak.Config{
+		ServerURL:    srv.URL,
+		Realm:        testRealm,
+		ClientID:     e2eClientID,
+		ClientSecret: e2eClientSecret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newConnector(client, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := newGroupBuilder(c)
+	users := listAll(t, newUserBuilder(c), nil)
+	membership := entitlementRef(groupResourceType, last, membershipSlug)
+
+	_, annos, err := builder.Grant(ctx, users[0], membership)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !annos.Contains(&v2.GrantAlreadyExists{}) {
+		t.Errorf("Grant of the 150th membership returned %v, want it already granted", annos)
+	}
+
+	annos, err = builder.Revoke(ctx, newGrant(membership, users[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if annos.Contains(&v2.GrantAlreadyRevoked{}) {
+		t.Error("Revoke of the 150th membership reported it already revoked")
+	}
+	if isMember(t, kc, alice, last) {
+		t.Error("alice is still a member of the 150th group")
+	}
+}
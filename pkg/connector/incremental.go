@@ -0,0 +1,407 @@
+package connector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+	"go.uber.org/zap"
+)
+
+const (
+	// incrementalOverlap re-reads events slightly older than the last refresh
+	// to absorb clock skew between the connector and Keycloak.
+	incrementalOverlap = time.Minute
+	cachePageSize      = 300
+)
+
+// userChangeEventTypes are the user events that create, change or remove a user
+// without going through the admin API, e.g. self registration.
+var userChangeEventTypes = []string{
+	"REGISTER",
+	"UPDATE_PROFILE",
+	"UPDATE_EMAIL",
+	"DELETE_ACCOUNT",
+	"IDENTITY_PROVIDER_FIRST_LOGIN",
+}
+
+// syncCache keeps the users and groups of the previous sync so the next one only
+// has to refetch what changed. The connector runs as a long lived service, so
+// the cache lives as long as the process. Users and groups are refreshed on the
+// first page of their List call and every later page is served from the cache.
+type syncCache struct {
+	client keycloak.API
+
+	mu           sync.Mutex
+	usersSynced  time.Time
+	users        map[string]*gocloak.User
+	userIDs      []string
+	groupsSynced time.Time
+	groups       map[string]*gocloak.Group
+	groupIDs     []string
+}
+
+func newSyncCache(client keycloak.API) *syncCache {
+	return &syncCache{client: client}
+}
+
+// Users returns a page of cached users and the token of the next page. The cache
+// is brought up to date when the first page is requested, or filled when empty.
+func (s *syncCache) Users(ctx context.Context, first int) ([]*gocloak.User, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A sync resuming from a saved page token after a restart finds the
+	// cache empty.
+	if first == 0 || s.users == nil {
+		if err := s.refreshUsers(ctx); err != nil {
+			return nil, "", err
+		}
+	}
+
+	ids, nextToken := cachePage(s.userIDs, first)
+	users := make([]*gocloak.User, 0, len(ids))
+	for _, id := range ids {
+		users = append(users, s.users[id])
+	}
+
+	return users, nextToken, nil
+}
+
+// Groups returns a page of cached groups and the token of the next page. The
+// cache is brought up to date when the first page is requested, or filled when
+// empty.
+func (s *syncCache) Groups(ctx context.Context, first int) ([]*gocloak.Group, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if first == 0 || s.groups == nil {
+		if err := s.refreshGroups(ctx); err != nil {
+			return nil, "", err
+		}
+	}
+
+	ids, nextToken := cachePage(s.groupIDs, first)
+	groups := make([]*gocloak.Group, 0, len(ids))
+	for _, id := range ids {
+		groups = append(groups, s.groups[id])
+	}
+
+	return groups, nextToken, nil
+}
+
+func (s *syncCache) refreshUsers(ctx context.Context) error {
+	l := ctxzap.Extract(ctx)
+	started := time.Now()
+
+	changed, ok, err := s.changedUsers(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		l.Info("running a full user sync")
+		users, err := s.listAllUsers(ctx)
+		if err != nil {
+			return err
+		}
+		s.users = users
+	} else {
+		l.Info("running an incremental user sync", zap.Int("changed_users", len(changed)))
+		for _, userID := range changed {
+			user, err := s.client.GetUser(ctx, userID)
+			switch {
+			case keycloak.IsNotFound(err):
+				delete(s.users, userID)
+			case err != nil:
+				return err
+			default:
+				s.users[userID] = user
+			}
+		}
+	}
+
+	s.userIDs = sortedKeys(s.users)
+	s.usersSynced = started
+	return nil
+}
+
+func (s *syncCache) refreshGroups(ctx context.Context) error {
+	l := ctxzap.Extract(ctx)
+	started := time.Now()
+
+	changed, ok, err := s.changedGroups(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		l.Info("running a full group sync")
+		groups, err := s.listAllGroups(ctx)
+		if err != nil {
+			return err
+		}
+		s.groups = groups
+	} else {
+		l.Info("running an incremental group sync", zap.Int("changed_groups", len(changed)))
+		for _, groupID := range changed {
+			group, err := s.client.GetGroup(ctx, groupID)
+			switch {
+			case keycloak.IsNotFound(err):
+				delete(s.groups, groupID)
+			case err != nil:
+				return err
+			case isTopLevelGroup(group):
+				// The full listing only returns top level groups, stay consistent with it.
+				s.groups[groupID] = group
+			default:
+				// Moved below another group.
+				delete(s.groups, groupID)
+			}
+		}
+	}
+
+	s.groupIDs = sortedKeys(s.groups)
+	s.groupsSynced = started
+	return nil
+}
+
+// changedUsers returns the IDs of users changed since the last refresh. ok is
+// false when a full sync is needed instead.
+func (s *syncCache) changedUsers(ctx context.Context) ([]string, bool, error) {
+	if s.users == nil {
+		return nil, false, nil
+	}
+
+	realm, ok, err := s.eventsUsable(ctx, s.usersSynced)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	// Self registration and account console changes never show up as admin events.
+	if !userEventsRecorded(realm) {
+		ctxzap.Extract(ctx).Info("user events are not recorded, falling back to a full user sync")
+		return nil, false, nil
+	}
+	if realm.EventsExpiration != nil && *realm.EventsExpiration > 0 &&
+		time.Since(s.usersSynced) > time.Duration(*realm.EventsExpiration)*time.Second {
+		ctxzap.Extract(ctx).Info("last sync is older than the user event retention, falling back to a full user sync")
+		return nil, false, nil
+	}
+
+	since := s.usersSynced.Add(-incrementalOverlap)
+	changed := make(map[string]struct{})
+
+	adminEvents, err := s.adminEventsSince(ctx, since, keycloak.AdminEventResourceUser)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, adminEvent := range adminEvents {
+		if id := adminEventObjectID(adminEvent, "users"); id != "" {
+			changed[id] = struct{}{}
+		}
+	}
+
+	for first := 0; ; first += adminEventsFetchSize {
+		events, err := s.client.GetUserEvents(ctx, userChangeEventTypes, since, first, adminEventsFetchSize)
+		if err != nil {
+			return nil, false, err
+		}
+
+		reachedSince := false
+		for _, event := range events {
+			if event.Time < since.UnixMilli() {
+				reachedSince = true
+				break
+			}
+			if userID := safeString(event.UserID); userID != "" {
+				changed[userID] = struct{}{}
+			}
+		}
+
+		if reachedSince || len(events) < adminEventsFetchSize {
+			break
+		}
+	}
+
+	return sortedKeys(changed), true, nil
+}
+
+// changedGroups returns the IDs of groups changed since the last refresh. ok is
+// false when a full sync is needed instead.
+func (s *syncCache) changedGroups(ctx context.Context) ([]string, bool, error) {
+	if s.groups == nil {
+		return nil, false, nil
+	}
+
+	_, ok, err := s.eventsUsable(ctx, s.groupsSynced)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	adminEvents, err := s.adminEventsSince(ctx, s.groupsSynced.Add(-incrementalOverlap), keycloak.AdminEventResourceGroup)
+	if err != nil {
+		return nil, false, err
+	}
+
+	changed := make(map[string]struct{})
+	for _, adminEvent := range adminEvents {
+		if id := adminEventObjectID(adminEvent, "groups"); id != "" {
+			changed[id] = struct{}{}
+		}
+		// A moved group is recorded against its new parent, or against groups
+		// when it moves to the top level, so its ID is in the representation.
+		if id := adminEventRepresentationID(adminEvent); id != "" {
+			changed[id] = struct{}{}
+		}
+	}
+
+	return sortedKeys(changed), true, nil
+}
+
+// eventsUsable reports whether admin events can be trusted to contain every
+// change since lastSync: they have to be recorded and must not have expired.
+func (s *syncCache) eventsUsable(ctx context.Context, lastSync time.Time) (*gocloak.RealmRepresentation, bool, error) {
+	l := ctxzap.Extract(ctx)
+
+	realm, err := s.client.GetRealm(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if realm.AdminEventsEnabled == nil || !*realm.AdminEventsEnabled {
+		l.Info("admin events are disabled, falling back to a full sync")
+		return realm, false, nil
+	}
+
+	if realm.Attributes != nil {
+		if expiration, ok := (*realm.Attributes)["adminEventsExpiration"]; ok && expiration != "" {
+			seconds, err := strconv.ParseInt(expiration, 10, 64)
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid adminEventsExpiration %q: %w", expiration, err)
+			}
+			if seconds > 0 && time.Since(lastSync) > time.Duration(seconds)*time.Second {
+				l.Info("last sync is older than the admin event retention, falling back to a full sync")
+				return realm, false, nil
+			}
+		}
+	}
+
+	return realm, true, nil
+}
+
+// adminEventsSince returns the successful admin events of resourceType recorded since the given time.
+func (s *syncCache) adminEventsSince(ctx context.Context, since time.Time, resourceType string) ([]*keycloak.AdminEvent, error) {
+	return adminEventsSince(ctx, s.client, since, []string{resourceType})
+}
+
+func (s *syncCache) listAllUsers(ctx context.Context) (map[string]*gocloak.User, error) {
+	users := make(map[string]*gocloak.User)
+	for first := 0; ; {
+		page, nextToken, err := s.client.GetUsers(ctx, keycloak.UserQuery{}, first)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range page {
+			users[safeString(user.ID)] = user
+		}
+		if nextToken == "" {
+			return users, nil
+		}
+		if first, err = strconv.Atoi(nextToken); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (s *syncCache) listAllGroups(ctx context.Context) (map[string]*gocloak.Group, error) {
+	groups := make(map[string]*gocloak.Group)
+	for first := 0; ; {
+		page, nextToken, err := s.client.GetGroups(ctx, first)
+		if err != nil {
+			return nil, err
+		}
+		for _, group := range page {
+			groups[safeString(group.ID)] = group
+		}
+		if nextToken == "" {
+			return groups, nil
+		}
+		if first, err = strconv.Atoi(nextToken); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// userEventsRecorded reports whether the realm stores the user events that
+// reveal changes made outside the admin API.
+func userEventsRecorded(realm *gocloak.RealmRepresentation) bool {
+	if realm.EventsEnabled == nil || !*realm.EventsEnabled {
+		return false
+	}
+	// An empty list means every event type is recorded.
+	if realm.EnabledEventTypes == nil || len(*realm.EnabledEventTypes) == 0 {
+		return true
+	}
+	for _, eventType := range userChangeEventTypes {
+		if !slices.Contains(*realm.EnabledEventTypes, eventType) {
+			return false
+		}
+	}
+	return true
+}
+
+// adminEventObjectID returns the ID following collection in the event's resource
+// path, e.g. the group ID of groups/<id>/children.
+func adminEventObjectID(adminEvent *keycloak.AdminEvent, collection string) string {
+	path := strings.Split(strings.Trim(adminEvent.ResourcePath, "/"), "/")
+	if len(path) < 2 || path[0] != collection {
+		return ""
+	}
+	return path[1]
+}
+
+// adminEventRepresentationID returns the ID of the object the event carries as
+// its representation, if any.
+func adminEventRepresentationID(adminEvent *keycloak.AdminEvent) string {
+	var representation struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal([]byte(adminEvent.Representation), &representation); err != nil {
+		return ""
+	}
+	return representation.ID
+}
+
+func isTopLevelGroup(group *gocloak.Group) bool {
+	return strings.Count(strings.Trim(safeString(group.Path), "/"), "/") == 0
+}
+
+func cachePage(ids []string, first int) ([]string, string) {
+	if first >= len(ids) {
+		return nil, ""
+	}
+
+	end := min(first+cachePageSize, len(ids))
+	if end == len(ids) {
+		return ids[first:end], ""
+	}
+	return ids[first:end], strconv.Itoa(end)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
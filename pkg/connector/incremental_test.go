@@ -0,0 +1,103 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak/fake"
+)
+
+// incrementalRealm returns a realm recording every event the sync cache relies on.
+func incrementalRealm() *fake.Keycloak {
+	kc := fake.New(testRealm)
+	kc.UpdateRealm(func(realm *gocloak.RealmRepresentation) {
+		realm.AdminEventsEnabled = gocloak.BoolP(true)
+		realm.EventsEnabled = gocloak.BoolP(true)
+	})
+	return kc
+}
+
+func TestIncrementalSyncEvictsMovedGroup(t *testing.T) {
+	kc := incrementalRealm()
+	eng := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("eng")}, "")
+	sre := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("sre")}, "")
+	c, err := newConnector(kc, Config{IncrementalSync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resourceIDs(listAll(t, newGroupBuilder(c), nil)); !slices.Equal(got, []string{eng, sre}) {
+		t.Fatalf("groups: %v, want eng and sre", got)
+	}
+
+	// Keycloak records the move against the new parent.
+	kc.MoveGroup(sre, eng)
+	kc.AddAdminEvent(keycloak.AdminEvent{
+		Time:           time.Now().UnixMilli(),
+		OperationType:  keycloak.AdminEventOperationUpdate,
+		ResourceType:   keycloak.AdminEventResourceGroup,
+		ResourcePath:   "groups/" + eng + "/children",
+		Representation: fmt.Sprintf(`{"id":%q,"name":"sre"}`, sre),
+	})
+
+	if got := resourceIDs(listAll(t, newGroupBuilder(c), nil)); !slices.Equal(got, []string{eng}) {
+		t.Errorf("groups after moving sre below eng: %v, want only eng", got)
+	}
+}
+
+func TestIncrementalSyncExpiredUserEvents(t *testing.T) {
+	kc := incrementalRealm()
+	kc.UpdateRealm(func(realm *gocloak.RealmRepresentation) {
+		realm.EventsExpiration = gocloak.Int64P(3600)
+	})
+	alice := kc.AddUser(gocloak.User{Username: gocloak.StringP("alice")})
+	c, err := newConnector(kc, Config{IncrementalSync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listAll(t, newUserBuilder(c), nil)
+
+	// bob registered while the connector was down, and the REGISTER event has
+	// since expired.
+	bob := kc.AddUser(gocloak.User{Username: gocloak.StringP("bob")})
+	c.cache.usersSynced = time.Now().Add(-2 * time.Hour)
+
+	if got := resourceIDs(listAll(t, newUserBuilder(c), nil)); !slices.Equal(got, []string{alice, bob}) {
+		t.Errorf("users: %v, want alice and bob from a full sync", got)
+	}
+}
+
+func TestIncrementalSyncResumesOnFreshConnector(t *testing.T) {
+	ctx := context.Background()
+	kc := incrementalRealm()
+	for i := range 400 {
+		kc.AddUser(gocloak.User{Username: gocloak.StringP(fmt.Sprintf("user-%03d", i))})
+		kc.AddGroup(gocloak.Group{Name: gocloak.StringP(fmt.Sprintf("group-%03d", i))}, "")
+	}
+	// As after a restart, the sync carries on from a saved page token.
+	c, err := newConnector(kc, Config{IncrementalSync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := &pagination.Token{Token: "300"}
+
+	users, next, _, err := newUserBuilder(c).List(ctx, nil, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 100 || next != "" {
+		t.Errorf("users from token 300: %d with next page %q, want the last 100", len(users), next)
+	}
+	groups, next, _, err := newGroupBuilder(c).List(ctx, nil, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 100 || next != "" {
+		t.Errorf("groups from token 300: %d with next page %q, want the last 100", len(groups), next)
+	}
+}
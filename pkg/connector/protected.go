@@ -0,0 +1,238 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Nerzal/gocloak/v13"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/types/resource"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// regexRulePrefix marks a protection rule as a regular expression rather than
+// a group path or role name.
+const regexRulePrefix = "regex:"
+
+// protectionPolicy keeps provisioning away from groups and roles that must
+// only ever be managed in Keycloak itself, such as the realm admins.
+type protectionPolicy struct {
+	groups []protectionRule
+	roles  []protectionRule
+	// hide marks protected entitlements immutable, so ConductorOne doesn't
+	// offer them for requests at all.
+	hide bool
+}
+
+// protectionRule matches a group path or role name exactly, or against
+// pattern for regex rules.
+type protectionRule struct {
+	rule    string
+	name    string
+	pattern *regexp.Regexp
+}
+
+// newProtectionPolicy parses the rules. Group rules are paths, a leading
+// slash being optional, role rules realm role names or clientId/role for
+// client roles. Either may instead be regex:<pattern>, matched against the
+// path or name.
+func newProtectionPolicy(groups, roles []string, hide bool) (*protectionPolicy, error) {
+	p := &protectionPolicy{hide: hide}
+	for _, rule := range groups {
+		parsed, err := parseProtectionRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		if parsed.pattern == nil && !strings.HasPrefix(parsed.name, "/") {
+			parsed.name = "/" + parsed.name
+		}
+		p.groups = append(p.groups, parsed)
+	}
+	for _, rule := range roles {
+		parsed, err := parseProtectionRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		p.roles = append(p.roles, parsed)
+	}
+	return p, nil
+}
+
+func parseProtectionRule(rule string) (protectionRule, error) {
+	expr, ok := strings.CutPrefix(rule, regexRulePrefix)
+	if !ok {
+		return protectionRule{rule: rule, name: rule}, nil
+	}
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return protectionRule{}, fmt.Errorf("invalid protection rule %q: %w", rule, err)
+	}
+	return protectionRule{rule: rule, pattern: pattern}, nil
+}
+
+// groupRule returns the rule protecting the group at path. Subgroups inherit
+// the role mappings of their parents, so they are protected along with them.
+func (p *protectionPolicy) groupRule(path string) (string, bool) {
+	for _, r := range p.groups {
+		if r.pattern != nil {
+			if r.pattern.MatchString(path) {
+				return r.rule, true
+			}
+			continue
+		}
+		if path == r.name || strings.HasPrefix(path, r.name+"/") {
+			return r.rule, true
+		}
+	}
+	return "", false
+}
+
+// roleRule returns the rule protecting the role named name, or clientId/name
+// for client roles.
+func (p *protectionPolicy) roleRule(name string) (string, bool) {
+	for _, r := range p.roles {
+		if (r.pattern != nil && r.pattern.MatchString(name)) || (r.pattern == nil && name == r.name) {
+			return r.rule, true
+		}
+	}
+	return "", false
+}
+
+// checkGroup refuses provisioning of a protected group.
+func (p *protectionPolicy) checkGroup(group *gocloak.Group) error {
+	path := safeString(group.Path)
+	if rule, ok := p.groupRule(path); ok {
+		return status.Errorf(codes.PermissionDenied, "group %s is protected from provisioning by the rule %q", path, rule)
+	}
+	return nil
+}
+
+// checkGroupRoles refuses provisioning of a group that holds a protected role,
+// mapped to it or one of its parents, directly or through composite roles, as
+// its members receive it.
+func (c *Connector) checkGroupRoles(ctx context.Context, group *gocloak.Group) error {
+	if len(c.protection.roles) == 0 {
+		return nil
+	}
+
+	// Subgroups inherit the role mappings of every group above them.
+	groupIDs := []string{safeString(group.ID)}
+	path := safeString(group.Path)
+	for i := len(path) - 1; i > 0; i-- {
+		if path[i] != '/' {
+			continue
+		}
+		parent, err := c.client.GetGroupByPath(ctx, path[:i])
+		if err != nil {
+			return fmt.Errorf("failed to get parent group %s: %w", path[:i], err)
+		}
+		groupIDs = append(groupIDs, safeString(parent.ID))
+	}
+
+	// Composites only carry the ID of the client defining them.
+	clientIDs := make(map[string]string)
+	var roles []gocloak.Role
+	for _, groupID := range groupIDs {
+		mappings, err := c.client.GetGroupRoleMappings(ctx, groupID)
+		if err != nil {
+			return fmt.Errorf("failed to get role mappings of group: %w", err)
+		}
+		for clientID, clientMappings := range mappings.ClientMappings {
+			if clientMappings != nil {
+				clientIDs[safeString(clientMappings.ID)] = clientID
+			}
+		}
+		roles = append(roles, mappedRoles(mappings)...)
+	}
+
+	seen := make(map[string]bool)
+	for len(roles) > 0 {
+		role := roles[0]
+		roles = roles[1:]
+		if seen[safeString(role.ID)] {
+			continue
+		}
+		seen[safeString(role.ID)] = true
+
+		name := safeString(role.Name)
+		if role.ClientRole != nil && *role.ClientRole {
+			containerID := safeString(role.ContainerID)
+			if _, ok := clientIDs[containerID]; !ok {
+				client, err := c.client.GetClient(ctx, containerID)
+				if err != nil {
+					return fmt.Errorf("failed to get client of role %s: %w", name, err)
+				}
+				clientIDs[containerID] = safeString(client.ClientID)
+			}
+			name = clientIDs[containerID] + "/" + name
+		}
+		if rule, ok := c.protection.roleRule(name); ok {
+			return status.Errorf(codes.PermissionDenied, "group %s holds the role %s, protected from provisioning by the rule %q", safeString(group.Path), name, rule)
+		}
+
+		if role.Composite != nil && *role.Composite {
+			composites, err := c.client.GetRoleComposites(ctx, safeString(role.ID))
+			if err != nil {
+				return fmt.Errorf("failed to get composites of role %s: %w", name, err)
+			}
+			for _, composite := range composites {
+				roles = append(roles, *composite)
+			}
+		}
+	}
+	return nil
+}
+
+// hideGroupEntitlement marks the entitlement of a group resource immutable
+// when protected entitlements are hidden and provisioning would refuse the
+// group, being protected itself or holding a protected role.
+func (c *Connector) hideGroupEntitlement(ctx context.Context, entitlement *v2.Entitlement) error {
+	if !c.protection.hide {
+		return nil
+	}
+	trait, err := resource.GetGroupTrait(entitlement.Resource)
+	if err != nil {
+		return nil
+	}
+	path, _ := resource.GetProfileStringValue(trait.Profile, "path")
+	if _, ok := c.protection.groupRule(path); ok {
+		markImmutable(entitlement)
+		return nil
+	}
+
+	err = c.checkGroupRoles(ctx, &gocloak.Group{ID: &entitlement.Resource.Id.Resource, Path: &path})
+	if status.Code(err) == codes.PermissionDenied {
+		markImmutable(entitlement)
+		return nil
+	}
+	return err
+}
+
+// hideRoleEntitlement marks the entitlement of a protected role resource
+// immutable when protected entitlements are hidden.
+func (p *protectionPolicy) hideRoleEntitlement(entitlement *v2.Entitlement) {
+	if !p.hide {
+		return
+	}
+	trait, err := resource.GetRoleTrait(entitlement.Resource)
+	if err != nil {
+		return
+	}
+	name, _ := resource.GetProfileStringValue(trait.Profile, "name")
+	if clientID, ok := resource.GetProfileStringValue(trait.Profile, "client_id"); ok {
+		name = clientID + "/" + name
+	}
+	if _, ok := p.roleRule(name); ok {
+		markImmutable(entitlement)
+	}
+}
+
+func markImmutable(entitlement *v2.Entitlement) {
+	annos := annotations.Annotations(entitlement.Annotations)
+	annos.Update(&v2.EntitlementImmutable{})
+	entitlement.Annotations = annos
+}
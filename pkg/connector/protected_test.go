@@ -0,0 +1,119 @@
+package connector
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v13"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak/fake"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestProtectedGroups(t *testing.T) {
+	ctx := context.Background()
+	kc := fake.New(testRealm)
+	alice := kc.AddUser(gocloak.User{Username: gocloak.StringP("alice")})
+	realmAdmins := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("realm-admins")}, "")
+	breakGlass := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("break-glass")}, realmAdmins)
+	opsAdmins := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("ops-admins")}, "")
+	developers := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("developers")}, "")
+	if err := kc.AddUserToGroup(ctx, alice, realmAdmins); err != nil {
+		t.Fatal(err)
+	}
+
+	realmManagement := kc.AddClient(gocloak.Client{ClientID: gocloak.StringP(keycloak.RealmManagementClientID)})
+	realmAdmin := kc.AddClientRole(realmManagement, gocloak.Role{Name: gocloak.StringP("realm-admin")})
+	operator := kc.AddRealmRole(gocloak.Role{Name: gocloak.StringP("operator")})
+	kc.AddComposite(operator, realmAdmin)
+	sre := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("sre")}, "")
+	kc.MapGroupRole(sre, operator)
+	oncall := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("oncall")}, sre)
+
+	c, err := newConnector(kc, Config{
+		ProtectedGroups: []string{"realm-admins", "regex:-admins$"},
+		ProtectedRoles:  []string{"realm-management/realm-admin"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := newGroupBuilder(c)
+	users := listAll(t, newUserBuilder(c), nil)
+
+	for name, groupID := range map[string]string{
+		"by path":             realmAdmins,
+		"as a subgroup":       breakGlass,
+		"by regex":            opsAdmins,
+		"through a composite": sre,
+		"through its parent":  oncall,
+	} {
+		membership := entitlementRef(groupResourceType, groupID, membershipSlug)
+		if _, _, err := builder.Grant(ctx, users[0], membership); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("Grant of a group protected %s: %v", name, err)
+		}
+		if _, err := builder.Revoke(ctx, newGrant(membership, users[0])); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("Revoke of a group protected %s: %v", name, err)
+		}
+	}
+	if !isMember(t, kc, alice, realmAdmins) {
+		t.Error("alice was removed from realm-admins")
+	}
+	for _, groupID := range []string{breakGlass, opsAdmins, sre, oncall} {
+		if isMember(t, kc, alice, groupID) {
+			t.Errorf("alice was added to protected group %s", groupID)
+		}
+	}
+
+	if _, _, err := builder.Grant(ctx, users[0], entitlementRef(groupResourceType, developers, membershipSlug)); err != nil {
+		t.Errorf("Grant of an unprotected group: %v", err)
+	}
+}
+
+func TestHideProtectedEntitlements(t *testing.T) {
+	ctx := context.Background()
+	kc := fake.New(testRealm)
+	kc.AddGroup(gocloak.Group{Name: gocloak.StringP("realm-admins")}, "")
+	kc.AddGroup(gocloak.Group{Name: gocloak.StringP("developers")}, "")
+	admin := kc.AddRealmRole(gocloak.Role{Name: gocloak.StringP("admin")})
+	kc.AddRealmRole(gocloak.Role{Name: gocloak.StringP("viewer")})
+	operators := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("operators")}, "")
+	kc.MapGroupRole(operators, admin)
+
+	c, err := newConnector(kc, Config{
+		ProtectedGroups:           []string{"/realm-admins"},
+		ProtectedRoles:            []string{"admin"},
+		HideProtectedEntitlements: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	immutable := make(map[string]bool)
+	for _, syncer := range []connectorbuilder.ResourceSyncer{newGroupBuilder(c), newRoleBuilder(c)} {
+		for _, r := range listAll(t, syncer, nil) {
+			entitlements, _, _, err := syncer.Entitlements(ctx, r, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			annos := annotations.Annotations(entitlements[0].Annotations)
+			immutable[r.DisplayName] = annos.Contains(&v2.EntitlementImmutable{})
+		}
+	}
+
+	want := map[string]bool{"realm-admins": true, "developers": false, "operators": true, "admin": true, "viewer": false}
+	for name, hidden := range want {
+		if immutable[name] != hidden {
+			t.Errorf("entitlement of %s immutable: %v, want %v", name, immutable[name], hidden)
+		}
+	}
+}
+
+func TestInvalidProtectionRule(t *testing.T) {
+	if _, err := newConnector(fake.New(testRealm), Config{ProtectedRoles: []string{"regex:("}}); err == nil {
+		t.Error("newConnector accepted an invalid regex rule")
+	}
+}
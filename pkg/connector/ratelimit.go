@@ -0,0 +1,138 @@
+package connector
+
+import (
+	"context"
+	"time"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
+	"github.com/conductorone/baton-sdk/pkg/metrics"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/conductorone/baton-sdk/pkg/ratelimit"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// rateLimitBackoff is how long the SDK is asked to wait when Keycloak keeps
+// refusing requests for load without saying for how long.
+const rateLimitBackoff = time.Minute
+
+// rateLimitedSyncer reports Keycloak's rate limit state on every page a
+// builder returns, and turns requests still refused for load after the client
+// retried them into errors the SDK waits on and retries.
+type rateLimitedSyncer struct {
+	connectorbuilder.ResourceSyncer
+}
+
+// rateLimitedProvisioner is rateLimitedSyncer for builders that also grant and
+// revoke, which must stay visible to the SDK.
+type rateLimitedProvisioner struct {
+	*rateLimitedSyncer
+	provisioner connectorbuilder.ResourceProvisionerV2
+}
+
+func (c *Connector) rateLimited(syncer connectorbuilder.ResourceSyncer) connectorbuilder.ResourceSyncer {
+	wrapped := &rateLimitedSyncer{ResourceSyncer: syncer}
+	if provisioner, ok := syncer.(connectorbuilder.ResourceProvisionerV2); ok {
+		return &rateLimitedProvisioner{rateLimitedSyncer: wrapped, provisioner: provisioner}
+	}
+	return wrapped
+}
+
+func (s *rateLimitedSyncer) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	ctx = keycloak.WithRateLimitStatus(ctx)
+	resources, nextToken, annos, err := s.ResourceSyncer.List(ctx, parentResourceID, pToken)
+	if err != nil {
+		return nil, "", nil, rateLimitError(ctx, err)
+	}
+	return resources, nextToken, annotateRateLimit(ctx, annos), nil
+}
+
+func (s *rateLimitedSyncer) Entitlements(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	ctx = keycloak.WithRateLimitStatus(ctx)
+	entitlements, nextToken, annos, err := s.ResourceSyncer.Entitlements(ctx, resource, pToken)
+	if err != nil {
+		return nil, "", nil, rateLimitError(ctx, err)
+	}
+	return entitlements, nextToken, annotateRateLimit(ctx, annos), nil
+}
+
+func (s *rateLimitedSyncer) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	ctx = keycloak.WithRateLimitStatus(ctx)
+	grants, nextToken, annos, err := s.ResourceSyncer.Grants(ctx, resource, pToken)
+	if err != nil {
+		return nil, "", nil, rateLimitError(ctx, err)
+	}
+	return grants, nextToken, annotateRateLimit(ctx, annos), nil
+}
+
+func (p *rateLimitedProvisioner) Grant(ctx context.Context, resource *v2.Resource, entitlement *v2.Entitlement) ([]*v2.Grant, annotations.Annotations, error) {
+	return p.provisioner.Grant(ctx, resource, entitlement)
+}
+
+func (p *rateLimitedProvisioner) Revoke(ctx context.Context, grant *v2.Grant) (annotations.Annotations, error) {
+	return p.provisioner.Revoke(ctx, grant)
+}
+
+// annotateRateLimit adds the rate limit headers of the latest response to the
+// call's requests, if Keycloak or its ingress sent any.
+func annotateRateLimit(ctx context.Context, annos annotations.Annotations) annotations.Annotations {
+	statusCode, header := keycloak.RateLimitStatus(ctx)
+	description, err := ratelimit.ExtractRateLimitData(statusCode, &header)
+	if err != nil || description == nil || description.Status == v2.RateLimitDescription_STATUS_UNSPECIFIED {
+		return annos
+	}
+
+	return *annos.WithRateLimiting(description)
+}
+
+// rateLimitError wraps an error from a request refused for load as
+// Unavailable, with the time to retry at, so the SDK backs off.
+func rateLimitError(ctx context.Context, err error) error {
+	if !keycloak.IsRateLimited(err) {
+		return err
+	}
+
+	statusCode, header := keycloak.RateLimitStatus(ctx)
+	description, _ := ratelimit.ExtractRateLimitData(statusCode, &header)
+	if description == nil || description.ResetAt.AsTime().Before(time.Now()) {
+		description = &v2.RateLimitDescription{
+			ResetAt: timestamppb.New(time.Now().Add(rateLimitBackoff)),
+		}
+	}
+	description.Status = v2.RateLimitDescription_STATUS_OVERLIMIT
+	// The SDK spreads the wait until the reset over the limit, the whole
+	// wait is wanted here.
+	description.Limit = 1
+
+	st, detailsErr := status.New(codes.Unavailable, err.Error()).WithDetails(description)
+	if detailsErr != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return st.Err()
+}
+
+// requestWaitObserver records the time requests spend waiting for the
+// client-side limits, and how many had to wait at all.
+func requestWaitObserver(handler metrics.Handler) keycloak.WaitObserver {
+	waitTime := handler.Int64Histogram(
+		"keycloak_request_wait",
+		"Time Keycloak requests waited for the client-side rate and concurrency limits",
+		metrics.Milliseconds,
+	)
+	waited := handler.Int64Counter(
+		"keycloak_requests_waited",
+		"Keycloak requests delayed by the client-side rate and concurrency limits",
+		metrics.Dimensionless,
+	)
+
+	return func(ctx context.Context, wait time.Duration) {
+		waitTime.Record(ctx, wait.Milliseconds(), nil)
+		if wait >= time.Millisecond {
+			waited.Add(ctx, 1, nil)
+		}
+	}
+}
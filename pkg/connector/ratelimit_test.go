@@ -0,0 +1,61 @@
+package connector
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v13"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak/fake"
+)
+
+func TestRateLimitAnnotationsBelongToTheCall(t *testing.T) {
+	ctx := context.Background()
+	kc := fake.New(testRealm)
+	kc.AddUser(gocloak.User{Username: gocloak.StringP("alice")})
+	srv := fake.NewServer(kc, e2eClientID, e2eClientSecret)
+	defer srv.Close()
+	// An ingress reporting its limits on every response.
+	ingress := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Ratelimit-Limit", "100")
+		w.Header().Set("X-Ratelimit-Remaining", "42")
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer ingress.Close()
+
+	client, err := keycloak.NewClient(keycloak.Config{
+		ServerURL:    ingress.URL,
+		Realm:        testRealm,
+		ClientID:     e2eClientID,
+		ClientSecret: e2eClientSecret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newConnector(client, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := c.rateLimited(newUserBuilder(c))
+
+	_, _, annos, err := users.List(ctx, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	description := &v2.RateLimitDescription{}
+	if ok, err := annos.Pick(description); err != nil || !ok || description.Remaining != 42 {
+		t.Errorf("user listing reports rate limit %v, want 42 requests remaining", description)
+	}
+
+	// Users below a group are listed without asking Keycloak.
+	_, _, annos, err = users.List(ctx, &v2.ResourceId{ResourceType: groupResourceType.Id, Resource: "admins"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if annos.Contains(&v2.RateLimitDescription{}) {
+		t.Error("a call without requests reports the rate limit of an earlier one")
+	}
+}
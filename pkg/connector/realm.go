@@ -0,0 +1,202 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// defaultsRefreshInterval keeps a sync from re-reading the realm defaults for
+// every grant.
+const defaultsRefreshInterval = 15 * time.Minute
+
+// realmBuilder syncs the realm itself. Its only entitlement is read-only and
+// describes what every user of the realm implicitly receives.
+type realmBuilder struct {
+	resourceType *v2.ResourceType
+	client       *Connector
+}
+
+func (o *realmBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
+	return realmResourceType
+}
+
+func (o *realmBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, _ *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	realm, err := o.client.client.GetRealm(ctx)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	name := safeString(realm.DisplayName)
+	if name == "" {
+		name = safeString(realm.Realm)
+	}
+
+	realmResource, err := resource.NewResource(
+		name,
+		realmResourceType,
+		safeString(realm.Realm),
+		resource.WithParentResourceID(parentResourceID),
+	)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return []*v2.Resource{realmResource}, "", nil, nil
+}
+
+func (o *realmBuilder) Entitlements(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	defaults, err := o.client.defaults.get(ctx)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	var received []string
+	if defaults.role != nil {
+		roles := []string{safeString(defaults.role.Name)}
+		for _, role := range defaults.composites {
+			roles = append(roles, safeString(role.Name))
+		}
+		received = append(received, fmt.Sprintf("roles %s", strings.Join(roles, ", ")))
+	}
+	if len(defaults.groups) > 0 {
+		groups := make([]string, 0, len(defaults.groups))
+		for _, group := range defaults.groups {
+			groups = append(groups, safeString(group.Path))
+		}
+		received = append(received, fmt.Sprintf("groups %s", strings.Join(groups, ", ")))
+	}
+
+	description := "Users of this realm receive nothing by default"
+	if len(received) > 0 {
+		description = fmt.Sprintf("Every user of this realm implicitly receives the %s", strings.Join(received, " and the "))
+	}
+
+	return []*v2.Entitlement{
+		{
+			Id:          entitlementID(realmResourceType.Id, resource.Id.Resource, defaultsSlug),
+			DisplayName: fmt.Sprintf("Default access in %s", resource.DisplayName),
+			Description: description,
+			GrantableTo: []*v2.ResourceType{userResourceType},
+			Slug:        defaultsSlug,
+			Resource:    resource,
+			Annotations: annotations.New(&v2.EntitlementImmutable{}),
+		},
+	}, "", nil, nil
+}
+
+func (o *realmBuilder) Grants(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+// defaultsSnapshot is what a realm hands every new user.
+type defaultsSnapshot struct {
+	// role is the default-roles-<realm> composite role, nil on old realms.
+	role       *gocloak.Role
+	composites []*gocloak.Role
+	groups     []*gocloak.Group
+}
+
+// realmDefaults reads the realm's default role and default groups and keeps
+// them for a while.
+type realmDefaults struct {
+	client keycloak.API
+
+	mu       sync.Mutex
+	loadedAt time.Time
+	snapshot *defaultsSnapshot
+}
+
+func newRealmDefaults(client keycloak.API) *realmDefaults {
+	return &realmDefaults{client: client}
+}
+
+func (d *realmDefaults) get(ctx context.Context) (*defaultsSnapshot, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.snapshot != nil && time.Since(d.loadedAt) < defaultsRefreshInterval {
+		return d.snapshot, nil
+	}
+
+	realm, err := d.client.GetRealm(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &defaultsSnapshot{role: realm.DefaultRole}
+	if snapshot.role != nil && snapshot.role.ID != nil {
+		snapshot.composites, err = d.client.GetRoleComposites(ctx, *snapshot.role.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	snapshot.groups, err = d.client.GetDefaultGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	d.snapshot = snapshot
+	d.loadedAt = time.Now()
+	return snapshot, nil
+}
+
+// isDefaultGroup reports whether every new user joins the group.
+func (d *realmDefaults) isDefaultGroup(ctx context.Context, groupID string) (bool, error) {
+	snapshot, err := d.get(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return slices.ContainsFunc(snapshot.groups, func(group *gocloak.Group) bool {
+		return safeString(group.ID) == groupID
+	}), nil
+}
+
+// isDefaultRole reports whether roleID is the realm's default role, which every
+// new user is mapped to.
+func (d *realmDefaults) isDefaultRole(ctx context.Context, roleID string) (bool, error) {
+	snapshot, err := d.get(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return snapshot.role != nil && safeString(snapshot.role.ID) == roleID, nil
+}
+
+// markDefault flags the grant metadata as access the user received implicitly
+// from the realm defaults rather than from a request.
+func markDefault(metadata *v2.GrantMetadata, source string) *v2.GrantMetadata {
+	if metadata == nil {
+		metadata = &v2.GrantMetadata{}
+	}
+	if metadata.Metadata == nil {
+		metadata.Metadata = &structpb.Struct{}
+	}
+	if metadata.Metadata.Fields == nil {
+		metadata.Metadata.Fields = make(map[string]*structpb.Value)
+	}
+
+	metadata.Metadata.Fields["implicit_default"] = structpb.NewBoolValue(true)
+	metadata.Metadata.Fields["default_source"] = structpb.NewStringValue(source)
+	return metadata
+}
+
+func newRealmBuilder(client *Connector) *realmBuilder {
+	return &realmBuilder{
+		resourceType: realmResourceType,
+		client:       client,
+	}
+}
@@ -5,6 +5,10 @@ import (
 )
 
 var (
+	realmResourceType = &v2.ResourceType{
+		Id:          "realm",
+		DisplayName: "Realm",
+	}
 	userResourceType = &v2.ResourceType{
 		Id:          "user",
 		DisplayName: "User",
@@ -15,4 +19,26 @@ var (
 		DisplayName: "Group",
 		Traits:      []v2.ResourceType_Trait{v2.ResourceType_TRAIT_GROUP},
 	}
+	clientResourceType = &v2.ResourceType{
+		Id:          "client",
+		DisplayName: "Client",
+		Traits:      []v2.ResourceType_Trait{v2.ResourceType_TRAIT_APP},
+	}
+	roleResourceType = &v2.ResourceType{
+		Id:          "role",
+		DisplayName: "Role",
+		Traits:      []v2.ResourceType_Trait{v2.ResourceType_TRAIT_ROLE},
+	}
+	clientScopeResourceType = &v2.ResourceType{
+		Id:          "client_scope",
+		DisplayName: "Client Scope",
+	}
+	authzResourceResourceType = &v2.ResourceType{
+		Id:          "authz_resource",
+		DisplayName: "Authorization Resource",
+	}
+	authzScopeResourceType = &v2.ResourceType{
+		Id:          "authz_scope",
+		DisplayName: "Authorization Scope",
+	}
 )
@@ -0,0 +1,240 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Nerzal/gocloak/v13"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+	"github.com/spiros-spiros/baton-keycloak/pkg/utils"
+)
+
+// roleBuilder syncs realm roles at the top level and client roles below the
+// client that defines them.
+type roleBuilder struct {
+	resourceType *v2.ResourceType
+	client       *Connector
+}
+
+func (o *roleBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
+	return roleResourceType
+}
+
+func (o *roleBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	if parentResourceID == nil {
+		roles, nextToken, err := o.client.client.GetRealmRoles(ctx, utils.ParseToken(pToken))
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		resources := make([]*v2.Resource, 0, len(roles))
+		for _, role := range roles {
+			roleResource, err := parseIntoRoleResource(role, nil, nil)
+			if err != nil {
+				return nil, "", nil, err
+			}
+			resources = append(resources, roleResource)
+		}
+
+		return resources, nextToken, nil, nil
+	}
+
+	if parentResourceID.ResourceType != clientResourceType.Id {
+		return nil, "", nil, nil
+	}
+
+	client, err := o.client.client.GetClient(ctx, parentResourceID.Resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	roles, nextToken, err := o.client.client.GetClientRoles(ctx, parentResourceID.Resource, utils.ParseToken(pToken))
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	resources := make([]*v2.Resource, 0, len(roles))
+	for _, role := range roles {
+		roleResource, err := parseIntoRoleResource(role, client, parentResourceID)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		resources = append(resources, roleResource)
+	}
+
+	return resources, nextToken, nil, nil
+}
+
+func (o *roleBuilder) Entitlements(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	entitlement := roleAssignedEntitlement(resource)
+	o.client.protection.hideRoleEntitlement(entitlement)
+	return []*v2.Entitlement{entitlement}, "", nil, nil
+}
+
+// Grants returns the users and groups the role is directly mapped to. Group
+// grants are expandable, so members of the group are reported as holding the
+// role as well. Grants of client roles carry the user's last use of the client.
+// Service accounts are left to userBuilder, which syncs them below their client.
+func (o *roleBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	role, err := roleFromResource(resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	var (
+		users     []*gocloak.User
+		groups    []*gocloak.Group
+		nextToken string
+		first     = utils.ParseToken(pToken)
+	)
+	if role.clientUUID == "" {
+		users, nextToken, err = o.client.client.GetRealmRoleUsers(ctx, role.name, first)
+	} else {
+		users, nextToken, err = o.client.client.GetClientRoleUsers(ctx, role.clientUUID, role.name, first)
+	}
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	// The role's groups are read in full with the first page of users.
+	if first == 0 {
+		if role.clientUUID == "" {
+			groups, err = o.client.client.GetRealmRoleGroups(ctx, role.name)
+		} else {
+			groups, err = o.client.client.GetClientRoleGroups(ctx, role.clientUUID, role.name)
+		}
+		if err != nil {
+			return nil, "", nil, err
+		}
+	}
+
+	entitlement := roleAssignedEntitlement(resource)
+
+	// Every new user is mapped to the realm's default role.
+	defaultRole, err := o.client.defaults.isDefaultRole(ctx, resource.Id.Resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	grants := make([]*v2.Grant, 0, len(users)+len(groups))
+	for _, user := range users {
+		// Service accounts report their own role mappings below their client.
+		if user.ServiceAccountClientID != nil {
+			continue
+		}
+		included, err := o.client.includesUser(ctx, user)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if !included {
+			continue
+		}
+
+		userResource, err := parseIntoUserResource(user, nil)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		var metadata *v2.GrantMetadata
+		if role.clientID != "" {
+			metadata = usageGrantMetadata(o.client.usage.LastClientUse(ctx, role.clientID, *user.ID))
+		}
+		if defaultRole {
+			metadata = markDefault(metadata, "default_role")
+		}
+		grants = append(grants, newGrant(entitlement, userResource, metadata))
+	}
+
+	for _, group := range groups {
+		if !o.client.scope.includesGroup(group) {
+			continue
+		}
+
+		groupResource, err := o.client.groupResource(group)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		grants = append(grants, newGrant(entitlement, groupResource, memberExpansion(groupResource)))
+	}
+
+	return grants, nextToken, nil, nil
+}
+
+// roleDetails is what the grant lookups need to know about a synced role.
+type roleDetails struct {
+	name string
+	// clientUUID and clientID are empty for realm roles.
+	clientUUID string
+	clientID   string
+}
+
+func roleFromResource(r *v2.Resource) (roleDetails, error) {
+	roleTrait, err := resource.GetRoleTrait(r)
+	if err != nil {
+		return roleDetails{}, err
+	}
+
+	name, ok := resource.GetProfileStringValue(roleTrait.Profile, "name")
+	if !ok || name == "" {
+		return roleDetails{}, fmt.Errorf("role %s has no name in its profile", r.Id.Resource)
+	}
+
+	details := roleDetails{name: name}
+	if r.ParentResourceId != nil && r.ParentResourceId.ResourceType == clientResourceType.Id {
+		details.clientUUID = r.ParentResourceId.Resource
+		details.clientID, _ = resource.GetProfileStringValue(roleTrait.Profile, "client_id")
+	}
+
+	return details, nil
+}
+
+// parseIntoRoleResource converts a realm role, when client is nil, or a client
+// role into a role resource.
+func parseIntoRoleResource(role *gocloak.Role, client *gocloak.Client, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
+	name := safeString(role.Name)
+	displayName := name
+
+	profile := map[string]interface{}{
+		"name":        name,
+		"description": safeString(role.Description),
+		"composite":   role.Composite != nil && *role.Composite,
+		"client_role": client != nil,
+	}
+
+	if client != nil {
+		clientID := safeString(client.ClientID)
+		profile["client_id"] = clientID
+		// realm-management roles are the realm's admin roles.
+		profile["admin_role"] = clientID == keycloak.RealmManagementClientID
+		displayName = fmt.Sprintf("%s/%s", clientID, name)
+	}
+
+	roleTraits := []resource.RoleTraitOption{
+		resource.WithRoleProfile(profile),
+	}
+
+	ret, err := resource.NewRoleResource(
+		displayName,
+		roleResourceType,
+		safeString(role.ID),
+		roleTraits,
+		resource.WithParentResourceID(parentResourceID),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+func newRoleBuilder(client *Connector) *roleBuilder {
+	return &roleBuilder{
+		resourceType: roleResourceType,
+		client:       client,
+	}
+}
@@ -0,0 +1,58 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v13"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak/fake"
+)
+
+func TestRoleGrantsManyGroups(t *testing.T) {
+	ctx := context.Background()
+	kc := fake.New(testRealm)
+	viewer := kc.AddRealmRole(gocloak.Role{Name: gocloak.StringP("viewer")})
+	billing := kc.AddClient(gocloak.Client{ClientID: gocloak.StringP("billing")})
+	payer := kc.AddClientRole(billing, gocloak.Role{Name: gocloak.StringP("payer")})
+	// More groups than Keycloak returns in one page by default.
+	for i := range 150 {
+		group := kc.AddGroup(gocloak.Group{Name: gocloak.StringP(fmt.Sprintf("team-%03d", i))}, "")
+		kc.MapGroupRole(group, viewer)
+		kc.MapGroupRole(group, payer)
+	}
+
+	srv := fake.NewServer(kc, e2eClientID, e2eClientSecret)
+	defer srv.Close()
+	client, err := keycloak.NewClient(keycloak.Config{
+		ServerURL:    srv.URL,
+		Realm:        testRealm,
+		ClientID:     e2eClientID,
+		ClientSecret: e2eClientSecret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newConnector(client, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := newRoleBuilder(c)
+
+	roles := listAll(t, builder, nil)
+	roles = append(roles, listAll(t, builder, &v2.ResourceId{ResourceType: clientResourceType.Id, Resource: billing})...)
+	for _, role := range roles {
+		if role.Id.Resource != viewer && role.Id.Resource != payer {
+			continue
+		}
+		grants, _, _, err := builder.Grants(ctx, role, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(grants) != 150 {
+			t.Errorf("%s has %d grants, want one for each of the 150 groups", role.DisplayName, len(grants))
+		}
+	}
+}
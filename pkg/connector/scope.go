@@ -0,0 +1,193 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+)
+
+// syncScope narrows the sync to part of the realm, e.g. to leave out the
+// customer users of a realm that also holds the workforce. The zero value
+// syncs everything.
+type syncScope struct {
+	// groupPrefixes are the paths of the top-level groups synced. Their
+	// subgroups come along with them.
+	groupPrefixes []string
+	// clientIDs are the clientIds of the clients synced, and with them their
+	// roles, service accounts and authorization resources.
+	clientIDs []string
+	// users filters the users synced.
+	users keycloak.UserQuery
+	// usersInGroups only syncs users that are members of a synced group.
+	usersInGroups bool
+
+	// members holds the members of the synced groups by ID, memberIDs their
+	// IDs sorted for paging. They are gathered when the user listing starts.
+	mu        sync.Mutex
+	members   map[string]*gocloak.User
+	memberIDs []string
+}
+
+// newSyncScope builds the scope from the configuration. Group prefixes are
+// paths of top-level groups, the leading slash being optional, user attributes
+// are given as key=value.
+func newSyncScope(cfg Config) (*syncScope, error) {
+	s := &syncScope{
+		clientIDs:     cfg.ClientIDs,
+		users:         keycloak.UserQuery{Search: cfg.UserSearch},
+		usersInGroups: cfg.UsersInGroupsOnly,
+	}
+	// Only top-level groups are listed, so a subgroup path would never match.
+	for _, rule := range cfg.GroupPathPrefixes {
+		prefix := "/" + strings.Trim(rule, "/")
+		if prefix == "/" || strings.Contains(prefix[1:], "/") {
+			return nil, fmt.Errorf("invalid group path prefix %q, want the path of a top-level group", rule)
+		}
+		s.groupPrefixes = append(s.groupPrefixes, prefix)
+	}
+	for _, attribute := range cfg.UserAttributes {
+		key, value, ok := strings.Cut(attribute, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid user attribute filter %q, want key=value", attribute)
+		}
+		if s.users.Attributes == nil {
+			s.users.Attributes = make(map[string]string)
+		}
+		s.users.Attributes[key] = value
+	}
+	return s, nil
+}
+
+// includesGroup reports whether the group is synced, being one of the
+// selected top-level groups or below one.
+func (s *syncScope) includesGroup(group *gocloak.Group) bool {
+	if len(s.groupPrefixes) == 0 {
+		return true
+	}
+	path := safeString(group.Path)
+	for _, prefix := range s.groupPrefixes {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// includesClient reports whether the client is synced.
+func (s *syncScope) includesClient(client *gocloak.Client) bool {
+	return len(s.clientIDs) == 0 || slices.Contains(s.clientIDs, safeString(client.ClientID))
+}
+
+// matchesUser applies the user filter to users that weren't listed through
+// Keycloak's search, e.g. from the sync cache or group members, by the same
+// rules as the search.
+func (s *syncScope) matchesUser(user *gocloak.User) bool {
+	return s.users.Matches(user)
+}
+
+// filterUsers returns the users matching the user filter.
+func (s *syncScope) filterUsers(users []*gocloak.User) []*gocloak.User {
+	filtered := make([]*gocloak.User, 0, len(users))
+	for _, user := range users {
+		if s.matchesUser(user) {
+			filtered = append(filtered, user)
+		}
+	}
+	return filtered
+}
+
+// includesUser reports whether the user is synced.
+func (c *Connector) includesUser(ctx context.Context, user *gocloak.User) (bool, error) {
+	if !c.scope.matchesUser(user) {
+		return false, nil
+	}
+	if !c.scope.usersInGroups {
+		return true, nil
+	}
+
+	c.scope.mu.Lock()
+	defer c.scope.mu.Unlock()
+	if c.scope.members == nil {
+		if err := c.refreshScopeMembers(ctx); err != nil {
+			return false, err
+		}
+	}
+	_, ok := c.scope.members[safeString(user.ID)]
+	return ok, nil
+}
+
+// scopeMembers returns a page of the members of the synced groups and the
+// token of the next page. They are gathered again when the first page is
+// requested.
+func (c *Connector) scopeMembers(ctx context.Context, first int) ([]*gocloak.User, string, error) {
+	c.scope.mu.Lock()
+	defer c.scope.mu.Unlock()
+
+	if first == 0 || c.scope.members == nil {
+		if err := c.refreshScopeMembers(ctx); err != nil {
+			return nil, "", err
+		}
+	}
+
+	ids, nextToken := cachePage(c.scope.memberIDs, first)
+	users := make([]*gocloak.User, 0, len(ids))
+	for _, id := range ids {
+		users = append(users, c.scope.members[id])
+	}
+	return users, nextToken, nil
+}
+
+// refreshScopeMembers gathers the members of the synced groups and their
+// subgroups. The caller holds c.scope.mu.
+func (c *Connector) refreshScopeMembers(ctx context.Context) error {
+	members := make(map[string]*gocloak.User)
+	err := forEachPage(func(first int) (string, error) {
+		groups, nextToken, err := c.client.GetGroups(ctx, first)
+		if err != nil {
+			return "", err
+		}
+		for _, group := range groups {
+			if !c.scope.includesGroup(group) {
+				continue
+			}
+			if err := c.addGroupMembers(ctx, group, members); err != nil {
+				return "", err
+			}
+		}
+		return nextToken, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c.scope.members = members
+	c.scope.memberIDs = sortedKeys(members)
+	return nil
+}
+
+// addGroupMembers adds the members of the group and of every group below it.
+func (c *Connector) addGroupMembers(ctx context.Context, group *gocloak.Group, members map[string]*gocloak.User) error {
+	users, err := c.client.GetGroupMembers(ctx, safeString(group.ID))
+	if err != nil {
+		return fmt.Errorf("failed to get members of group %s: %w", safeString(group.Path), err)
+	}
+	for _, user := range users {
+		members[safeString(user.ID)] = user
+	}
+
+	subGroups, err := c.client.GetSubGroups(ctx, safeString(group.ID))
+	if err != nil {
+		return fmt.Errorf("failed to get subgroups of group %s: %w", safeString(group.Path), err)
+	}
+	for _, subGroup := range subGroups {
+		if err := c.addGroupMembers(ctx, subGroup, members); err != nil {
+			return err
+		}
+	}
+	return nil
+}
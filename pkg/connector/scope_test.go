@@ -0,0 +1,280 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v13"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak/fake"
+)
+
+// scopedRealm seeds a realm shared by staff and customers.
+func scopedRealm(t *testing.T) (kc *fake.Keycloak, staff, customers string) {
+	t.Helper()
+
+	kc = fake.New(testRealm)
+	kc.PageSize = 1
+	alice := kc.AddUser(gocloak.User{
+		Username:   gocloak.StringP("alice"),
+		Email:      gocloak.StringP("alice@example.com"),
+		Attributes: &map[string][]string{"department": {"sre"}},
+	})
+	kc.AddUser(gocloak.User{
+		Username:   gocloak.StringP("bob"),
+		Email:      gocloak.StringP("bob@example.com"),
+		Attributes: &map[string][]string{"department": {"sales"}},
+	})
+	carol := kc.AddUser(gocloak.User{
+		Username: gocloak.StringP("carol"),
+		Email:    gocloak.StringP("carol@customer.org"),
+	})
+
+	staff = kc.AddGroup(gocloak.Group{Name: gocloak.StringP("staff")}, "")
+	customers = kc.AddGroup(gocloak.Group{Name: gocloak.StringP("customers")}, "")
+	// Shares the staff prefix without being below it.
+	kc.AddGroup(gocloak.Group{Name: gocloak.StringP("staff-customers")}, "")
+	ctx := context.Background()
+	for userID, groupID := range map[string]string{alice: staff, carol: customers} {
+		if err := kc.AddUserToGroup(ctx, userID, groupID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	kc.AddClient(gocloak.Client{ClientID: gocloak.StringP("intranet")})
+	kc.AddClient(gocloak.Client{ClientID: gocloak.StringP("storefront")})
+	return kc, staff, customers
+}
+
+func TestSyncScopeUsers(t *testing.T) {
+	for name, tc := range map[string]struct {
+		cfg         Config
+		incremental bool
+		want        []string
+	}{
+		"everything":           {want: []string{"alice", "bob", "carol"}},
+		"search":               {cfg: Config{UserSearch: "*example.com*"}, want: []string{"alice", "bob"}},
+		"attributes":           {cfg: Config{UserAttributes: []string{"department=sre"}}, want: []string{"alice"}},
+		"search from cache":    {cfg: Config{UserSearch: "*example.com*"}, incremental: true, want: []string{"alice", "bob"}},
+		"members":              {cfg: Config{UsersInGroupsOnly: true}, want: []string{"alice", "carol"}},
+		"members of prefixes":  {cfg: Config{UsersInGroupsOnly: true, GroupPathPrefixes: []string{"/staff"}}, want: []string{"alice"}},
+		"members and searched": {cfg: Config{UsersInGroupsOnly: true, UserSearch: "carol"}, want: []string{"carol"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			kc, _, _ := scopedRealm(t)
+			tc.cfg.IncrementalSync = tc.incremental
+			c, err := newConnector(kc, tc.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := displayNames(listAll(t, newUserBuilder(c), nil))
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("users: %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestSyncScopeUserSearchRules checks that users filtered in the connector
+// match the ones Keycloak's search returns for the same user_search.
+func TestSyncScopeUserSearchRules(t *testing.T) {
+	for search, want := range map[string][]string{
+		"ali":                 {"alice"},
+		"lice":                nil,
+		"*lice":               {"alice"},
+		"*example.com":        {"alice", "bob"},
+		"a*example":           {"alice"},
+		`"alice"`:             {"alice"},
+		`"ali"`:               nil,
+		"ALICE":               {"alice"},
+		"bob *example":        {"bob"},
+		`carol "carol@x.org"`: nil,
+	} {
+		for name, cfg := range map[string]Config{
+			"keycloak": {UserSearch: search},
+			"cache":    {UserSearch: search, IncrementalSync: true},
+		} {
+			kc, _, _ := scopedRealm(t)
+			c, err := newConnector(kc, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := displayNames(listAll(t, newUserBuilder(c), nil)); !slices.Equal(got, want) && len(got)+len(want) > 0 {
+				t.Errorf("search %q through %s: %v, want %v", search, name, got, want)
+			}
+		}
+	}
+}
+
+func TestSyncScopeUsersOverHTTP(t *testing.T) {
+	kc, _, _ := scopedRealm(t)
+	srv := fake.NewServer(kc, e2eClientID, e2eClientSecret)
+	defer srv.Close()
+
+	client, err := keycloak.NewClient(keycloak.Config{
+		ServerURL:    srv.URL,
+		Realm:        testRealm,
+		ClientID:     e2eClientID,
+		ClientSecret: e2eClientSecret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newConnector(client, Config{UserSearch: "*example*", UserAttributes: []string{"department=sales"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := displayNames(listAll(t, newUserBuilder(c), nil)); !slices.Equal(got, []string{"bob"}) {
+		t.Errorf("users: %v, want only bob", got)
+	}
+}
+
+func TestSyncScopeMembersOfLargeGroup(t *testing.T) {
+	ctx := context.Background()
+	kc := fake.New(testRealm)
+	staff := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("staff")}, "")
+	// More members than Keycloak returns in one page by default.
+	for i := range 250 {
+		userID := kc.AddUser(gocloak.User{Username: gocloak.StringP(fmt.Sprintf("user-%03d", i))})
+		if err := kc.AddUserToGroup(ctx, userID, staff); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kc.AddUser(gocloak.User{Username: gocloak.StringP("customer")})
+
+	srv := fake.NewServer(kc, e2eClientID, e2eClientSecret)
+	defer srv.Close()
+	client, err := keycloak.NewClient(keycloak.Config{
+		ServerURL:    srv.URL,
+		Realm:        testRealm,
+		ClientID:     e2eClientID,
+		ClientSecret: e2eClientSecret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newConnector(client, Config{UsersInGroupsOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(listAll(t, newUserBuilder(c), nil)); got != 250 {
+		t.Errorf("synced %d users, want the 250 members of staff", got)
+	}
+	grants, _, _, err := newGroupBuilder(c).Grants(ctx, listAll(t, newGroupBuilder(c), nil)[0], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grants) != 250 {
+		t.Errorf("staff has %d grants, want 250", len(grants))
+	}
+}
+
+func TestSyncScopeMembersOfSubgroups(t *testing.T) {
+	ctx := context.Background()
+	kc, staff, _ := scopedRealm(t)
+	sre := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("sre")}, staff)
+	oncall := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("oncall")}, sre)
+	// Only a member of a group two levels below staff.
+	dave := kc.AddUser(gocloak.User{Username: gocloak.StringP("dave")})
+	if err := kc.AddUserToGroup(ctx, dave, oncall); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := fake.NewServer(kc, e2eClientID, e2eClientSecret)
+	defer srv.Close()
+	client, err := keycloak.NewClient(keycloak.Config{
+		ServerURL:    srv.URL,
+		Realm:        testRealm,
+		ClientID:     e2eClientID,
+		ClientSecret: e2eClientSecret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newConnector(client, Config{UsersInGroupsOnly: true, GroupPathPrefixes: []string{"/staff"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := displayNames(listAll(t, newUserBuilder(c), nil)); !slices.Equal(got, []string{"alice", "dave"}) {
+		t.Errorf("users: %v, want alice and dave", got)
+	}
+}
+
+func TestSyncScopeGroupsAndClients(t *testing.T) {
+	kc, staff, _ := scopedRealm(t)
+	c, err := newConnector(kc, Config{
+		GroupPathPrefixes: []string{"/staff"},
+		ClientIDs:         []string{"intranet"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := resourceIDs(listAll(t, newGroupBuilder(c), nil)); !slices.Equal(got, []string{staff}) {
+		t.Errorf("groups: %v, want only staff", got)
+	}
+	if got := displayNames(listAll(t, newClientBuilder(c), nil)); !slices.Equal(got, []string{"intranet"}) {
+		t.Errorf("clients: %v, want only intranet", got)
+	}
+}
+
+func TestSyncScopeRoleGrants(t *testing.T) {
+	ctx := context.Background()
+	kc, staff, customers := scopedRealm(t)
+	viewer := kc.AddRealmRole(gocloak.Role{Name: gocloak.StringP("viewer")})
+	kc.MapGroupRole(staff, viewer)
+	kc.MapGroupRole(customers, viewer)
+	for _, user := range listAll(t, newUserBuilder(newTestConnector(kc)), nil) {
+		kc.MapUserRole(user.Id.Resource, viewer)
+	}
+
+	c, err := newConnector(kc, Config{UsersInGroupsOnly: true, GroupPathPrefixes: []string{"/staff"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	grants, _, _, err := newRoleBuilder(c).Grants(ctx, listAll(t, newRoleBuilder(c), nil)[0], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, grant := range grants {
+		got = append(got, grant.Principal.Id.ResourceType+":"+grant.Principal.DisplayName)
+	}
+	want := []string{"user:alice", "group:staff"}
+	if !slices.Equal(got, want) {
+		t.Errorf("viewer grants: %v, want %v", got, want)
+	}
+}
+
+func TestInvalidGroupPathPrefix(t *testing.T) {
+	for _, prefix := range []string{"/staff/sre", "/"} {
+		if _, err := newConnector(fake.New(testRealm), Config{GroupPathPrefixes: []string{prefix}}); err == nil {
+			t.Errorf("newConnector accepted the group path prefix %q", prefix)
+		}
+	}
+	if _, err := newConnector(fake.New(testRealm), Config{GroupPathPrefixes: []string{"staff"}}); err != nil {
+		t.Errorf("newConnector rejected a prefix without a leading slash: %v", err)
+	}
+}
+
+func TestInvalidUserAttributeFilter(t *testing.T) {
+	if _, err := newConnector(fake.New(testRealm), Config{UserAttributes: []string{"department"}}); err == nil {
+		t.Error("newConnector accepted a user attribute filter without a value")
+	}
+}
+
+func displayNames(resources []*v2.Resource) []string {
+	names := make([]string, 0, len(resources))
+	for _, r := range resources {
+		names = append(names, r.DisplayName)
+	}
+	return names
+}
@@ -0,0 +1,194 @@
+package connector
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	// usageWindow is how far back login events are read. Keycloak may expire
+	// events sooner, depending on the realm's event expiration.
+	usageWindow = 90 * 24 * time.Hour
+	// usageRefreshInterval keeps a single sync from re-reading the event log
+	// for every grant while still picking up new logins on the next sync.
+	usageRefreshInterval = 15 * time.Minute
+	usageEventsFetchSize = 300
+)
+
+// usageEventTypes are the user events that count as using a client.
+var usageEventTypes = []string{"LOGIN", "CODE_TO_TOKEN"}
+
+// usageTracker computes last login per user and last use per user and client
+// from Keycloak user events.
+type usageTracker struct {
+	client keycloak.API
+
+	mu       sync.Mutex
+	loadedAt time.Time
+	// window is how far back the events read cover, zero when usage is unknown
+	// because events aren't recorded or couldn't be read.
+	window time.Duration
+	// lastLogin maps user IDs to their most recent login.
+	lastLogin map[string]time.Time
+	// clientUsage maps clientIds, then user IDs, to the most recent use.
+	clientUsage map[string]map[string]time.Time
+}
+
+// usage is the most recent use of a grant within window. A zero window means
+// usage is unknown, not that the grant went unused.
+type usage struct {
+	lastUsed time.Time
+	used     bool
+	window   time.Duration
+}
+
+func (u usage) known() bool {
+	return u.window > 0
+}
+
+func newUsageTracker(client keycloak.API) *usageTracker {
+	return &usageTracker{client: client}
+}
+
+// LastLogin returns the most recent login of the user within the usage window.
+func (u *usageTracker) LastLogin(ctx context.Context, userID string) usage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.load(ctx)
+	lastLogin, ok := u.lastLogin[userID]
+	return usage{lastUsed: lastLogin, used: ok, window: u.window}
+}
+
+// LastClientUse returns the most recent time the user logged in to, or exchanged
+// a code at, the client identified by its clientId.
+func (u *usageTracker) LastClientUse(ctx context.Context, clientID, userID string) usage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.load(ctx)
+	lastUse, ok := u.clientUsage[clientID][userID]
+	return usage{lastUsed: lastUse, used: ok, window: u.window}
+}
+
+// load reads the login events of the usage window unless they were read recently.
+// Usage is informational, so failures are logged and the sync carries on
+// without it rather than failing.
+func (u *usageTracker) load(ctx context.Context) {
+	if time.Since(u.loadedAt) < usageRefreshInterval {
+		return
+	}
+
+	l := ctxzap.Extract(ctx)
+	u.loadedAt = time.Now()
+	u.lastLogin = make(map[string]time.Time)
+	u.clientUsage = make(map[string]map[string]time.Time)
+
+	realm, err := u.client.GetRealm(ctx)
+	if err != nil {
+		l.Warn("failed to get realm event settings, usage will not be reported", zap.Error(err))
+		u.window = 0
+		return
+	}
+	u.window = loginEventsWindow(realm)
+	if u.window == 0 {
+		l.Info("login events are not recorded, usage will not be reported")
+		return
+	}
+
+	dateFrom := u.loadedAt.Add(-u.window)
+	for first := 0; ; first += usageEventsFetchSize {
+		events, err := u.client.GetUserEvents(ctx, usageEventTypes, dateFrom, first, usageEventsFetchSize)
+		if err != nil {
+			l.Warn("failed to load login events, usage will not be reported", zap.Error(err))
+			// Partial usage would report the users left unread as unused.
+			u.window = 0
+			u.lastLogin = make(map[string]time.Time)
+			u.clientUsage = make(map[string]map[string]time.Time)
+			return
+		}
+
+		for _, event := range events {
+			u.record(event.Time, safeString(event.UserID), safeString(event.ClientID))
+		}
+
+		if len(events) < usageEventsFetchSize {
+			break
+		}
+	}
+
+	l.Debug("loaded usage from login events",
+		zap.Int("users", len(u.lastLogin)),
+		zap.Int("clients", len(u.clientUsage)),
+		zap.Duration("window", u.window),
+	)
+}
+
+// loginEventsWindow returns how far back the realm's login events reach, at
+// most usageWindow, or zero when login events aren't recorded. Keycloak deletes
+// events older than the realm's event expiration.
+func loginEventsWindow(realm *gocloak.RealmRepresentation) time.Duration {
+	if realm.EventsEnabled == nil || !*realm.EventsEnabled {
+		return 0
+	}
+	// An empty list means every event type is recorded.
+	if realm.EnabledEventTypes != nil && len(*realm.EnabledEventTypes) > 0 &&
+		!slices.Contains(*realm.EnabledEventTypes, "LOGIN") {
+		return 0
+	}
+	if realm.EventsExpiration != nil && *realm.EventsExpiration > 0 {
+		return min(usageWindow, time.Duration(*realm.EventsExpiration)*time.Second)
+	}
+	return usageWindow
+}
+
+func (u *usageTracker) record(eventTime int64, userID, clientID string) {
+	if userID == "" {
+		return
+	}
+
+	at := time.UnixMilli(eventTime)
+	if at.After(u.lastLogin[userID]) {
+		u.lastLogin[userID] = at
+	}
+
+	if clientID == "" {
+		return
+	}
+	if u.clientUsage[clientID] == nil {
+		u.clientUsage[clientID] = make(map[string]time.Time)
+	}
+	if at.After(u.clientUsage[clientID][userID]) {
+		u.clientUsage[clientID][userID] = at
+	}
+}
+
+// usageGrantMetadata describes when a grant was last used so reviewers can spot
+// access that has gone unused for the whole usage window. It is nil when usage
+// is unknown.
+func usageGrantMetadata(u usage) *v2.GrantMetadata {
+	if !u.known() {
+		return nil
+	}
+
+	fields := map[string]*structpb.Value{
+		"usage_window_days": structpb.NewNumberValue(u.window.Hours() / 24),
+		"unused_in_window":  structpb.NewBoolValue(!u.used),
+	}
+	if u.used {
+		fields["last_used_at"] = structpb.NewStringValue(u.lastUsed.UTC().Format(time.RFC3339))
+	}
+
+	return &v2.GrantMetadata{
+		Metadata: &structpb.Struct{Fields: fields},
+	}
+}
@@ -0,0 +1,67 @@
+package connector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak/fake"
+)
+
+func TestUsageGrantMetadata(t *testing.T) {
+	ctx := context.Background()
+	for name, tc := range map[string]struct {
+		realm gocloak.RealmRepresentation
+		// wantWindow is the usage window reported in days, zero when the grant
+		// should carry no usage metadata.
+		wantWindow float64
+	}{
+		"events disabled":  {realm: gocloak.RealmRepresentation{EventsEnabled: gocloak.BoolP(false)}},
+		"logins not saved": {realm: gocloak.RealmRepresentation{EventsEnabled: gocloak.BoolP(true), EnabledEventTypes: &[]string{"REGISTER"}}},
+		"events enabled":   {realm: gocloak.RealmRepresentation{EventsEnabled: gocloak.BoolP(true)}, wantWindow: 90},
+		"events expire": {
+			realm:      gocloak.RealmRepresentation{EventsEnabled: gocloak.BoolP(true), EventsExpiration: gocloak.Int64P(int64(7 * 24 * time.Hour / time.Second))},
+			wantWindow: 7,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			kc := fake.New(testRealm)
+			kc.UpdateRealm(func(realm *gocloak.RealmRepresentation) { *realm = tc.realm })
+			alice := kc.AddUser(gocloak.User{Username: gocloak.StringP("alice")})
+			admins := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("admins")}, "")
+			if err := kc.AddUserToGroup(ctx, alice, admins); err != nil {
+				t.Fatal(err)
+			}
+
+			builder := newGroupBuilder(newTestConnector(kc))
+			grants, _, _, err := builder.Grants(ctx, listAll(t, builder, nil)[0], nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			metadata := &v2.GrantMetadata{}
+			annos := annotations.Annotations(grants[0].Annotations)
+			ok, err := annos.Pick(metadata)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantWindow == 0 {
+				if ok {
+					t.Errorf("grant has usage metadata %v while usage is unknown", metadata.GetMetadata())
+				}
+				return
+			}
+
+			fields := metadata.GetMetadata().GetFields()
+			if got := fields["usage_window_days"].GetNumberValue(); got != tc.wantWindow {
+				t.Errorf("usage window of %v days, want %v", got, tc.wantWindow)
+			}
+			if !fields["unused_in_window"].GetBoolValue() {
+				t.Error("grant without logins not reported as unused")
+			}
+		})
+	}
+}
@@ -2,7 +2,6 @@ package connector
 
 import (
 	"context"
-	"fmt"
 
 	"github.com/Nerzal/gocloak/v13"
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
@@ -39,112 +38,119 @@ func (o *userBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
 func (o *userBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
 	annos := annotations.Annotations{}
 
-	users, nextToken, err := o.client.client.GetUsers(ctx, utils.ParseToken(pToken))
+	// Below a client the only user is its service account.
+	if parentResourceID != nil {
+		if parentResourceID.ResourceType != clientResourceType.Id {
+			return nil, "", annos, nil
+		}
+		return o.listServiceAccount(ctx, parentResourceID)
+	}
+
+	var (
+		users     []*gocloak.User
+		nextToken string
+		err       error
+	)
+	switch {
+	case o.client.scope.usersInGroups:
+		users, nextToken, err = o.client.scopeMembers(ctx, utils.ParseToken(pToken))
+	case o.client.cache != nil:
+		users, nextToken, err = o.client.cache.Users(ctx, utils.ParseToken(pToken))
+	default:
+		users, nextToken, err = o.client.client.GetUsers(ctx, o.client.scope.users, utils.ParseToken(pToken))
+	}
 	if err != nil {
 		return nil, "", nil, err
 	}
+	// Keycloak only filters the users it lists itself.
+	if o.client.scope.usersInGroups || o.client.cache != nil {
+		users = o.client.scope.filterUsers(users)
+	}
 
-	resource := make([]*v2.Resource, 0, len(users))
+	if err := o.client.revokeExpiredGrants(ctx, users); err != nil {
+		return nil, "", nil, err
+	}
+
+	resources := make([]*v2.Resource, 0, len(users))
 	for _, user := range users {
-		userResource, err := parseIntoUserResource(user, nil)
+		var traitOptions []resource.UserTraitOption
+		if lastLogin := o.client.usage.LastLogin(ctx, safeString(user.ID)); lastLogin.used {
+			traitOptions = append(traitOptions, resource.WithLastLogin(lastLogin.lastUsed))
+		}
+
+		userResource, err := parseIntoUserResource(user, nil, traitOptions...)
 		if err != nil {
 			return nil, "", nil, err
 		}
-		resource = append(resource, userResource)
+		resources = append(resources, userResource)
 	}
 
-	return resource, nextToken, annos, nil
+	return resources, nextToken, annos, nil
 }
 
-// Entitlements returns entitlements for the user resource.
-// Parameters:
-//   - ctx: Context for cancellation and timeouts
-//   - resource: The user resource
-//   - pToken: Pagination token for handling large result sets
-//
-// Returns:
-//   - []*v2.Entitlement: List of entitlements for the user
-//   - string: Next page token for pagination
-//   - annotations.Annotations: Additional metadata
-//   - error: Any error that occurred during the operation
-func (o *userBuilder) Entitlements(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
-	userID := resource.Id.Resource
-	var entitlements []*v2.Entitlement
-
-	// Get all groups the user is a member of
-	groups, err := o.client.client.GetUserGroups(ctx, userID)
+// listServiceAccount returns the service-account user of a client, or nothing
+// when the client has service accounts disabled. Keycloak leaves service
+// accounts out of the user listing, so they are only synced here.
+func (o *userBuilder) listServiceAccount(ctx context.Context, parentResourceID *v2.ResourceId) ([]*v2.Resource, string, annotations.Annotations, error) {
+	client, err := o.client.client.GetClient(ctx, parentResourceID.Resource)
 	if err != nil {
 		return nil, "", nil, err
 	}
+	if client.ServiceAccountsEnabled == nil || !*client.ServiceAccountsEnabled {
+		return nil, "", nil, nil
+	}
 
-	for _, group := range groups {
-		groupResource, err := parseIntoGroupResource(group, nil)
-		if err != nil {
-			return nil, "", nil, err
-		}
-
-		// Create an entitlement for each group membership
-		membershipEntitlement := &v2.Entitlement{
-			Id:          fmt.Sprintf("user:%s:group:%s", userID, *group.ID),
-			DisplayName: fmt.Sprintf("Membership in %s", *group.Name),
-			Description: fmt.Sprintf("Membership in the %s group", *group.Name),
-			GrantableTo: []*v2.ResourceType{userResourceType},
-			Slug:        "membership",
-			Resource:    groupResource,
-		}
+	user, err := o.client.client.GetClientServiceAccount(ctx, parentResourceID.Resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
 
-		entitlements = append(entitlements, membershipEntitlement)
+	userResource, err := parseIntoUserResource(user, parentResourceID)
+	if err != nil {
+		return nil, "", nil, err
 	}
 
-	return entitlements, "", nil, nil
+	return []*v2.Resource{userResource}, "", nil, nil
 }
 
-// Grants returns grants for the user resource.
-// Parameters:
-//   - ctx: Context for cancellation and timeouts
-//   - resource: The user resource
-//   - pToken: Pagination token for handling large result sets
-//
-// Returns:
-//   - []*v2.Grant: List of grants for the user
-//   - string: Next page token for pagination
-//   - annotations.Annotations: Additional metadata
-//   - error: Any error that occurred during the operation
-func (o *userBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
-	var grants []*v2.Grant
-	annos := annotations.Annotations{}
+// Entitlements returns entitlements for the user resource.
+// Users carry no entitlements of their own, the groups they are members of
+// offer the membership entitlements their grants refer to.
+func (o *userBuilder) Entitlements(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
 
-	userID := resource.Id.Resource
+// Grants returns the role mappings of service accounts. Group memberships are
+// left to groupBuilder, which emits them from the group's side, and roleBuilder
+// leaves service accounts out.
+func (o *userBuilder) Grants(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	if resource.ParentResourceId == nil || resource.ParentResourceId.ResourceType != clientResourceType.Id {
+		return nil, "", nil, nil
+	}
 
-	// Get all groups the user is a member of
-	groups, err := o.client.client.GetUserGroups(ctx, userID)
+	grants, err := o.serviceAccountRoleGrants(ctx, resource)
 	if err != nil {
 		return nil, "", nil, err
 	}
+	return grants, "", nil, nil
+}
 
-	for _, group := range groups {
-		groupResource, err := parseIntoGroupResource(group, nil)
-		if err != nil {
-			return nil, "", nil, err
-		}
-
-		grant := &v2.Grant{
-			Id: fmt.Sprintf("grant:%s:%s", userID, *group.ID),
-			Entitlement: &v2.Entitlement{
-				Id:          fmt.Sprintf("user:%s:group:%s", userID, *group.ID),
-				DisplayName: fmt.Sprintf("Membership in %s", *group.Name),
-				Description: fmt.Sprintf("Membership in the %s group", *group.Name),
-				GrantableTo: []*v2.ResourceType{userResourceType},
-				Slug:        "membership",
-				Resource:    groupResource,
-			},
-			Principal: resource,
-		}
+// serviceAccountRoleGrants returns a grant for every realm and client role
+// mapped directly to a service-account user.
+func (o *userBuilder) serviceAccountRoleGrants(ctx context.Context, resource *v2.Resource) ([]*v2.Grant, error) {
+	mappings, err := o.client.client.GetUserRoleMappings(ctx, resource.Id.Resource)
+	if err != nil {
+		return nil, err
+	}
 
-		grants = append(grants, grant)
+	roles := mappedRoles(mappings)
+	grants := make([]*v2.Grant, 0, len(roles))
+	for _, role := range roles {
+		roleID := safeString(role.ID)
+		grants = append(grants, newGrant(entitlementRef(roleResourceType, roleID, assignedSlug), resource))
 	}
 
-	return grants, "", annos, nil
+	return grants, nil
 }
 
 // newUserBuilder creates a new instance of userBuilder.
@@ -161,12 +167,16 @@ func newUserBuilder(client *Connector) *userBuilder {
 //   - ctx: Context (currently unused)
 //   - user: Pointer to the Linode user object to convert
 //   - parentResourceID: Optional parent resource ID for hierarchy
+//   - traitOptions: Extra user trait options, e.g. the last login
 //
 // Returns:
 //   - *v2.Resource: The converted Baton resource
 //   - error: Any conversion error that occurred
-func parseIntoUserResource(user *gocloak.User, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
+func parseIntoUserResource(user *gocloak.User, parentResourceID *v2.ResourceId, traitOptions ...resource.UserTraitOption) (*v2.Resource, error) {
 	var userStatus = v2.UserTrait_Status_STATUS_ENABLED
+	if user.Enabled != nil && !*user.Enabled {
+		userStatus = v2.UserTrait_Status_STATUS_DISABLED
+	}
 	username := safeString(user.Username)
 
 	profile := map[string]interface{}{
@@ -176,11 +186,19 @@ func parseIntoUserResource(user *gocloak.User, parentResourceID *v2.ResourceId)
 		"lastName":  safeString(user.LastName),
 	}
 
+	accountType := v2.UserTrait_ACCOUNT_TYPE_HUMAN
+	if clientID := safeString(user.ServiceAccountClientID); clientID != "" {
+		profile["service_account_client_id"] = clientID
+		accountType = v2.UserTrait_ACCOUNT_TYPE_SERVICE
+	}
+
 	userTraits := []resource.UserTraitOption{
 		resource.WithUserProfile(profile),
 		resource.WithUserLogin(username),
 		resource.WithStatus(userStatus),
+		resource.WithAccountType(accountType),
 	}
+	userTraits = append(userTraits, traitOptions...)
 
 	ret, err := resource.NewUserResource(
 		username,
@@ -0,0 +1,86 @@
+package connector
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v13"
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak/fake"
+)
+
+func TestUserListPaginates(t *testing.T) {
+	kc := fake.New(testRealm)
+	kc.PageSize = 2
+	alice := kc.AddUser(gocloak.User{Username: gocloak.StringP("alice")})
+	bob := kc.AddUser(gocloak.User{Username: gocloak.StringP("bob"), Enabled: gocloak.BoolP(false)})
+	carol := kc.AddUser(gocloak.User{Username: gocloak.StringP("carol")})
+
+	users := listAll(t, newUserBuilder(newTestConnector(kc)), nil)
+
+	if got, want := resourceIDs(users), []string{alice, bob, carol}; !slices.Equal(got, want) {
+		t.Fatalf("listed users %v, want %v", got, want)
+	}
+
+	userTrait, err := resource.GetUserTrait(users[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userTrait.Status.Status != v2.UserTrait_Status_STATUS_DISABLED {
+		t.Errorf("disabled user has status %v", userTrait.Status.Status)
+	}
+}
+
+func TestUserListServiceAccountBelowClient(t *testing.T) {
+	kc := fake.New(testRealm)
+	kc.AddUser(gocloak.User{Username: gocloak.StringP("alice")})
+	clientID := kc.AddClient(gocloak.Client{
+		ClientID:               gocloak.StringP("billing"),
+		ServiceAccountsEnabled: gocloak.BoolP(true),
+	})
+	serviceAccount := kc.AddUser(gocloak.User{Username: gocloak.StringP("service-account-billing")})
+	kc.SetServiceAccount(clientID, serviceAccount)
+
+	builder := newUserBuilder(newTestConnector(kc))
+
+	topLevel := listAll(t, builder, nil)
+	if slices.Contains(resourceIDs(topLevel), serviceAccount) {
+		t.Error("service account listed with the realm's users")
+	}
+
+	parent := &v2.ResourceId{ResourceType: clientResourceType.Id, Resource: clientID}
+	children := listAll(t, builder, parent)
+	if got := resourceIDs(children); !slices.Equal(got, []string{serviceAccount}) {
+		t.Fatalf("listed %v below the client, want its service account", got)
+	}
+
+	userTrait, err := resource.GetUserTrait(children[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userTrait.AccountType != v2.UserTrait_ACCOUNT_TYPE_SERVICE {
+		t.Errorf("service account has account type %v", userTrait.AccountType)
+	}
+}
+
+func TestUserGrantsLeaveMembershipsToGroups(t *testing.T) {
+	ctx := context.Background()
+	kc := fake.New(testRealm)
+	alice := kc.AddUser(gocloak.User{Username: gocloak.StringP("alice")})
+	admins := kc.AddGroup(gocloak.Group{Name: gocloak.StringP("admins")}, "")
+	if err := kc.AddUserToGroup(ctx, alice, admins); err != nil {
+		t.Fatal(err)
+	}
+
+	// The membership is emitted once, by the group.
+	builder := newUserBuilder(newTestConnector(kc))
+	grants, _, _, err := builder.Grants(ctx, listAll(t, builder, nil)[0], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(grants) != 0 {
+		t.Errorf("user has grants %v, want none", grants)
+	}
+}
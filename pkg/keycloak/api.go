@@ -0,0 +1,74 @@
+package keycloak
+
+import (
+	"context"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// API is the set of admin operations the connector uses. *Client implements it
+// against a Keycloak server, the fake package in memory for tests.
+type API interface {
+	// Users and group membership.
+	GetUsers(ctx context.Context, query UserQuery, first int) ([]*gocloak.User, string, error)
+	GetUser(ctx context.Context, userID string) (*gocloak.User, error)
+	GetUserGroups(ctx context.Context, userID string) ([]*gocloak.Group, error)
+	EnableUser(ctx context.Context, userID string) error
+	DisableUser(ctx context.Context, userID string) error
+	ClearUserBruteForceLockout(ctx context.Context, userID string) error
+	// UpdateUserAttribute replaces the values of one user attribute with the
+	// ones update returns for its current values, removing it when they are
+	// empty. The values are read and written in one go, so concurrent updates
+	// of the user don't lose each other's changes.
+	UpdateUserAttribute(ctx context.Context, userID, name string, update func(values []string) []string) error
+	GetGroups(ctx context.Context, first int) ([]*gocloak.Group, string, error)
+	GetGroup(ctx context.Context, groupID string) (*gocloak.Group, error)
+	// GetGroupByPath looks a group up by its full path, e.g. /engineering/admins.
+	GetGroupByPath(ctx context.Context, path string) (*gocloak.Group, error)
+	// GetSubGroups returns the direct subgroups of a group.
+	GetSubGroups(ctx context.Context, groupID string) ([]*gocloak.Group, error)
+	GetGroupMembers(ctx context.Context, groupID string) ([]*gocloak.User, error)
+	AddUserToGroup(ctx context.Context, userID, groupID string) error
+	RemoveUserFromGroup(ctx context.Context, userID, groupID string) error
+
+	// Realm, roles and clients.
+	GetRealm(ctx context.Context) (*gocloak.RealmRepresentation, error)
+	GetDefaultGroups(ctx context.Context) ([]*gocloak.Group, error)
+	GetRealmRoles(ctx context.Context, first int) ([]*gocloak.Role, string, error)
+	GetRealmRoleUsers(ctx context.Context, roleName string, first int) ([]*gocloak.User, string, error)
+	GetRealmRoleGroups(ctx context.Context, roleName string) ([]*gocloak.Group, error)
+	GetClientRoleUsers(ctx context.Context, idOfClient, roleName string, first int) ([]*gocloak.User, string, error)
+	GetClientRoleGroups(ctx context.Context, idOfClient, roleName string) ([]*gocloak.Group, error)
+	GetRoleComposites(ctx context.Context, roleID string) ([]*gocloak.Role, error)
+	GetUserRoleMappings(ctx context.Context, userID string) (*gocloak.MappingsRepresentation, error)
+	GetGroupRoleMappings(ctx context.Context, groupID string) (*gocloak.MappingsRepresentation, error)
+	GetClients(ctx context.Context, first int) ([]*gocloak.Client, string, error)
+	GetClient(ctx context.Context, idOfClient string) (*gocloak.Client, error)
+	GetClientRoles(ctx context.Context, idOfClient string, first int) ([]*gocloak.Role, string, error)
+	GetClientServiceAccount(ctx context.Context, idOfClient string) (*gocloak.User, error)
+
+	// Client scopes.
+	GetClientScopes(ctx context.Context) ([]*gocloak.ClientScope, error)
+	GetRealmDefaultClientScopes(ctx context.Context) ([]*gocloak.ClientScope, []*gocloak.ClientScope, error)
+	GetClientScopeScopeMappings(ctx context.Context, scopeID string) (*gocloak.MappingsRepresentation, error)
+
+	// Fine-grained admin permissions and Authorization Services.
+	GetGroupManagementPermissions(ctx context.Context, groupID string) (*gocloak.ManagementPermissionRepresentation, error)
+	GetClientManagementPermissions(ctx context.Context, idOfClient string) (*gocloak.ManagementPermissionRepresentation, error)
+	RealmManagementClient(ctx context.Context) (string, error)
+	GetAuthzResources(ctx context.Context, idOfClient string, first int) ([]*gocloak.ResourceRepresentation, string, error)
+	GetAuthzScopes(ctx context.Context, idOfClient string, first int) ([]*gocloak.ScopeRepresentation, string, error)
+	GetAuthzPermissions(ctx context.Context, idOfClient string, first int) ([]*gocloak.PermissionRepresentation, string, error)
+	GetAuthzPermissionResources(ctx context.Context, idOfClient, permissionID string) ([]*gocloak.PermissionResource, error)
+	GetAuthzPermissionScopes(ctx context.Context, idOfClient, permissionID string) ([]*gocloak.PermissionScope, error)
+	GetAssociatedPolicies(ctx context.Context, idOfClient, policyID string) ([]*gocloak.PolicyRepresentation, error)
+
+	// Events.
+	GetAdminEvents(ctx context.Context, params GetAdminEventsParams) ([]*AdminEvent, error)
+	GetUserEvents(ctx context.Context, eventTypes []string, dateFrom time.Time, first, max int) ([]*gocloak.EventRepresentation, error)
+
+	Close() error
+}
+
+var _ API = (*Client)(nil)
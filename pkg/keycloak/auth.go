@@ -0,0 +1,210 @@
+package keycloak
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// AuthMethod selects how the connector logs in to Keycloak.
+type AuthMethod string
+
+const (
+	// AuthClientSecret is the client credentials grant with a shared secret.
+	AuthClientSecret AuthMethod = "client_secret"
+	// AuthSignedJWT is the client credentials grant with a JWT assertion signed
+	// by the client's private key, Keycloak verifies it against the client's
+	// certificate or JWKS URL.
+	AuthSignedJWT AuthMethod = "signed_jwt"
+	// AuthMTLS is the client credentials grant with the client authenticated
+	// by its TLS certificate.
+	AuthMTLS AuthMethod = "mtls"
+	// AuthAdminPassword is the password grant of an admin user through the
+	// admin-cli client.
+	AuthAdminPassword AuthMethod = "admin_password"
+)
+
+// AuthMethods lists every supported AuthMethod.
+var AuthMethods = []string{
+	string(AuthClientSecret),
+	string(AuthSignedJWT),
+	string(AuthMTLS),
+	string(AuthAdminPassword),
+}
+
+// clientAssertionLifetime is how long a signed client assertion is accepted,
+// it is only used once right away.
+const clientAssertionLifetime = time.Minute
+
+// Config holds everything needed to reach, log in to and pace requests to
+// Keycloak.
+type Config struct {
+	ServerURL string
+	Realm     string
+	// AuthRealm is the realm the connector logs in to, e.g. master for a
+	// service account administering other realms. Defaults to Realm.
+	AuthRealm string
+
+	// AuthMethod defaults to AuthClientSecret.
+	AuthMethod   AuthMethod
+	ClientID     string
+	ClientSecret string
+
+	// PrivateKeyPath is a PEM private key signing the client assertions of
+	// AuthSignedJWT. PrivateKeyID is sent as the kid header so Keycloak can
+	// pick the key from a JWKS, SigningAlgorithm defaults to RS256.
+	PrivateKeyPath   string
+	PrivateKeyID     string
+	SigningAlgorithm string
+
+	// ClientCertPath and ClientKeyPath are a PEM client certificate and key
+	// presented on every connection, required with AuthMTLS.
+	ClientCertPath string
+	ClientKeyPath  string
+
+	// CACertPath is a PEM bundle of CAs trusted in addition to the system
+	// ones. MinTLSVersion is "1.2" (default) or "1.3". InsecureSkipVerify
+	// turns off certificate verification, for dev clusters only.
+	CACertPath         string
+	MinTLSVersion      string
+	InsecureSkipVerify bool
+	// ProxyURL is the HTTP proxy to reach Keycloak through. Defaults to the
+	// HTTPS_PROXY and NO_PROXY environment variables.
+	ProxyURL string
+
+	// AdminUsername and AdminPassword log in with AuthAdminPassword.
+	AdminUsername string
+	AdminPassword string
+
+	// MaxRequestsPerSecond and MaxInFlightRequests limit the load the client
+	// puts on Keycloak, zero leaves them off. WaitObserver, if set, is told
+	// how long each request waited for them.
+	MaxRequestsPerSecond float64
+	MaxInFlightRequests  int
+	WaitObserver         WaitObserver
+}
+
+// newLogin returns how the token source logs in with the configured method.
+func (c *Client) newLogin(cfg Config) (loginFunc, error) {
+	switch cfg.AuthMethod {
+	case "", AuthClientSecret:
+		if cfg.ClientID == "" || cfg.ClientSecret == "" {
+			return nil, errors.New("client_secret authentication needs a client ID and secret")
+		}
+		return func(ctx context.Context) (*gocloak.JWT, error) {
+			return c.client.LoginClient(ctx, cfg.ClientID, cfg.ClientSecret, c.authRealm)
+		}, nil
+
+	case AuthSignedJWT:
+		if cfg.ClientID == "" || cfg.PrivateKeyPath == "" {
+			return nil, errors.New("signed_jwt authentication needs a client ID and private key")
+		}
+		method, key, err := loadSigningKey(cfg.PrivateKeyPath, cfg.SigningAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context) (*gocloak.JWT, error) {
+			assertion, err := c.clientAssertion(cfg.ClientID, cfg.PrivateKeyID, method, key)
+			if err != nil {
+				return nil, err
+			}
+			return c.client.GetToken(ctx, c.authRealm, gocloak.TokenOptions{
+				ClientID:            gocloak.StringP(cfg.ClientID),
+				GrantType:           gocloak.StringP("client_credentials"),
+				ClientAssertionType: gocloak.StringP("urn:ietf:params:oauth:client-assertion-type:jwt-bearer"),
+				ClientAssertion:     gocloak.StringP(assertion),
+			})
+		}, nil
+
+	case AuthMTLS:
+		if cfg.ClientID == "" || cfg.ClientCertPath == "" || cfg.ClientKeyPath == "" {
+			return nil, errors.New("mtls authentication needs a client ID, certificate and key")
+		}
+		// The certificate itself is presented by the transport.
+		return func(ctx context.Context) (*gocloak.JWT, error) {
+			return c.client.GetToken(ctx, c.authRealm, gocloak.TokenOptions{
+				ClientID:  gocloak.StringP(cfg.ClientID),
+				GrantType: gocloak.StringP("client_credentials"),
+			})
+		}, nil
+
+	case AuthAdminPassword:
+		if cfg.AdminUsername == "" || cfg.AdminPassword == "" {
+			return nil, errors.New("admin_password authentication needs an admin username and password")
+		}
+		return func(ctx context.Context) (*gocloak.JWT, error) {
+			return c.client.LoginAdmin(ctx, cfg.AdminUsername, cfg.AdminPassword, c.authRealm)
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown authentication method %q", cfg.AuthMethod)
+	}
+}
+
+// clientAssertion signs a single-use JWT identifying the client to the token
+// endpoint of the realm.
+func (c *Client) clientAssertion(clientID, keyID string, method jwt.SigningMethod, key interface{}) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(method, jwt.RegisteredClaims{
+		Issuer:    clientID,
+		Subject:   clientID,
+		Audience:  jwt.ClaimStrings{fmt.Sprintf("%s/realms/%s", c.serverURL, c.authRealm)},
+		ID:        hex.EncodeToString(jti),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(clientAssertionLifetime)),
+	})
+	if keyID != "" {
+		token.Header["kid"] = keyID
+	}
+
+	assertion, err := token.SignedString(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign client assertion: %w", err)
+	}
+
+	return assertion, nil
+}
+
+// loadSigningKey reads a PEM private key usable with the signing algorithm.
+func loadSigningKey(path, algorithm string) (jwt.SigningMethod, interface{}, error) {
+	if algorithm == "" {
+		algorithm = jwt.SigningMethodRS256.Alg()
+	}
+
+	method := jwt.GetSigningMethod(algorithm)
+	if method == nil {
+		return nil, nil, fmt.Errorf("unknown signing algorithm %q", algorithm)
+	}
+
+	pemKey, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+
+	var key interface{}
+	switch method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		key, err = jwt.ParseRSAPrivateKeyFromPEM(pemKey)
+	case *jwt.SigningMethodECDSA:
+		key, err = jwt.ParseECPrivateKeyFromPEM(pemKey)
+	default:
+		return nil, nil, fmt.Errorf("signing algorithm %q is not supported for client assertions", algorithm)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	return method, key, nil
+}
@@ -0,0 +1,193 @@
+package keycloak
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// writePEM writes a PEM block to a file of its own and returns its path.
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func pkcs8(t *testing.T, key crypto.PrivateKey) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaPKCS1 := writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+	rsaPKCS8 := writePEM(t, "PRIVATE KEY", pkcs8(t, rsaKey))
+	ecSEC1 := writePEM(t, "EC PRIVATE KEY", ecDER)
+	ecPKCS8 := writePEM(t, "PRIVATE KEY", pkcs8(t, ecKey))
+	notPEM := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(notPEM, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range map[string]struct {
+		path      string
+		algorithm string
+		wantAlg   string
+		wantErr   bool
+	}{
+		"RSA PKCS#1 by default": {path: rsaPKCS1, wantAlg: "RS256"},
+		"RSA PKCS#8":            {path: rsaPKCS8, algorithm: "RS512", wantAlg: "RS512"},
+		"RSA-PSS":               {path: rsaPKCS1, algorithm: "PS256", wantAlg: "PS256"},
+		"EC SEC 1":              {path: ecSEC1, algorithm: "ES256", wantAlg: "ES256"},
+		"EC PKCS#8":             {path: ecPKCS8, algorithm: "ES256", wantAlg: "ES256"},
+		"EC key for RSA":        {path: ecSEC1, algorithm: "RS256", wantErr: true},
+		"RSA key for EC":        {path: rsaPKCS8, algorithm: "ES256", wantErr: true},
+		"not PEM":               {path: notPEM, wantErr: true},
+		"missing file":          {path: filepath.Join(t.TempDir(), "missing.pem"), wantErr: true},
+		"unknown algorithm":     {path: rsaPKCS1, algorithm: "RS1024", wantErr: true},
+		"shared secret":         {path: rsaPKCS1, algorithm: "HS256", wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			method, key, err := loadSigningKey(tc.path, tc.algorithm)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("loaded a %T key for %s", key, method.Alg())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if method.Alg() != tc.wantAlg {
+				t.Errorf("signing with %s, want %s", method.Alg(), tc.wantAlg)
+			}
+		})
+	}
+}
+
+func TestSignedJWTClientAssertion(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertions := make(chan string, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/realms/master/protocol/openid-connect/token" {
+			t.Errorf("token requested from %s, want the auth realm", r.URL.Path)
+		}
+		if got := r.PostFormValue("client_assertion_type"); got != "urn:ietf:params:oauth:client-assertion-type:jwt-bearer" {
+			t.Errorf("client assertion type %q", got)
+		}
+		assertions <- r.PostFormValue("client_assertion")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"token","expires_in":300}`)
+	}))
+	defer srv.Close()
+
+	cfg := Config{
+		ServerURL:      srv.URL,
+		Realm:          "test",
+		AuthRealm:      "master",
+		AuthMethod:     AuthSignedJWT,
+		ClientID:       "baton",
+		PrivateKeyPath: writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)),
+		PrivateKeyID:   "key-1",
+	}
+	c, err := NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	login, err := c.newLogin(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for range 2 {
+		if _, err := login(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		claims := &jwt.RegisteredClaims{}
+		token, err := jwt.ParseWithClaims(<-assertions, claims, func(token *jwt.Token) (interface{}, error) {
+			return &key.PublicKey, nil
+		}, jwt.WithValidMethods([]string{"RS256"}))
+		if err != nil {
+			t.Fatalf("client assertion doesn't verify: %v", err)
+		}
+
+		if token.Header["kid"] != "key-1" {
+			t.Errorf("kid %v, want key-1", token.Header["kid"])
+		}
+		if claims.Issuer != "baton" || claims.Subject != "baton" {
+			t.Errorf("issuer %q and subject %q, want the client ID", claims.Issuer, claims.Subject)
+		}
+		if wantAud := srv.URL + "/realms/master"; len(claims.Audience) != 1 || claims.Audience[0] != wantAud {
+			t.Errorf("audience %v, want %s", claims.Audience, wantAud)
+		}
+		if claims.ExpiresAt == nil || time.Until(claims.ExpiresAt.Time) > clientAssertionLifetime {
+			t.Errorf("expires at %v, want within %v", claims.ExpiresAt, clientAssertionLifetime)
+		}
+		if claims.ID == "" {
+			t.Error("client assertion has no jti")
+		}
+		ids = append(ids, claims.ID)
+	}
+	if ids[0] == ids[1] {
+		t.Errorf("two client assertions share the jti %s", ids[0])
+	}
+}
+
+func TestNewLoginRequiresCredentials(t *testing.T) {
+	for name, cfg := range map[string]Config{
+		"client secret without secret": {ClientID: "baton"},
+		"signed JWT without key":       {AuthMethod: AuthSignedJWT, ClientID: "baton"},
+		"mTLS without certificate":     {AuthMethod: AuthMTLS, ClientID: "baton", ClientKeyPath: "key.pem"},
+		"admin without password":       {AuthMethod: AuthAdminPassword, AdminUsername: "admin"},
+		"unknown method":               {AuthMethod: "kerberos", ClientID: "baton", ClientSecret: "secret"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg.ServerURL = "https://keycloak.example.com"
+			cfg.Realm = "test"
+			if _, err := NewClient(cfg); err == nil {
+				t.Error("NewClient accepted the configuration")
+			}
+		})
+	}
+}
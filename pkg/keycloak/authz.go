@@ -0,0 +1,113 @@
+package keycloak
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// GetAuthzResources lists the Authorization Services resources protected by a client.
+func (c *Client) GetAuthzResources(ctx context.Context, idOfClient string, first int) ([]*gocloak.ResourceRepresentation, string, error) {
+	token, err := c.tokens.TokenContext(ctx)
+	if err != nil {
+		return nil, strconv.Itoa(first), fmt.Errorf("failed to get token: %w", err)
+	}
+
+	max := 300
+
+	resources, err := c.client.GetResources(ctx, token.AccessToken, c.realm, idOfClient, gocloak.GetResourceParams{
+		Deep:  pointer(true),
+		First: pointer(first),
+		Max:   pointer(max),
+	})
+	if err != nil {
+		return nil, strconv.Itoa(first), fmt.Errorf("failed to get authorization resources: %w", err)
+	}
+
+	if len(resources) == 0 {
+		return nil, "", nil
+	}
+
+	return resources, strconv.Itoa(first + max), nil
+}
+
+// GetAuthzScopes lists the Authorization Services scopes defined by a client.
+func (c *Client) GetAuthzScopes(ctx context.Context, idOfClient string, first int) ([]*gocloak.ScopeRepresentation, string, error) {
+	token, err := c.tokens.TokenContext(ctx)
+	if err != nil {
+		return nil, strconv.Itoa(first), fmt.Errorf("failed to get token: %w", err)
+	}
+
+	max := 300
+
+	scopes, err := c.client.GetScopes(ctx, token.AccessToken, c.realm, idOfClient, gocloak.GetScopeParams{
+		First: pointer(first),
+		Max:   pointer(max),
+	})
+	if err != nil {
+		return nil, strconv.Itoa(first), fmt.Errorf("failed to get authorization scopes: %w", err)
+	}
+
+	if len(scopes) == 0 {
+		return nil, "", nil
+	}
+
+	return scopes, strconv.Itoa(first + max), nil
+}
+
+// GetAuthzPermissions lists the resource and scope based permissions of a client.
+func (c *Client) GetAuthzPermissions(ctx context.Context, idOfClient string, first int) ([]*gocloak.PermissionRepresentation, string, error) {
+	token, err := c.tokens.TokenContext(ctx)
+	if err != nil {
+		return nil, strconv.Itoa(first), fmt.Errorf("failed to get token: %w", err)
+	}
+
+	max := 300
+
+	permissions, err := c.client.GetPermissions(ctx, token.AccessToken, c.realm, idOfClient, gocloak.GetPermissionParams{
+		First: pointer(first),
+		Max:   pointer(max),
+	})
+	if err != nil {
+		return nil, strconv.Itoa(first), fmt.Errorf("failed to get authorization permissions: %w", err)
+	}
+
+	if len(permissions) == 0 {
+		return nil, "", nil
+	}
+
+	return permissions, strconv.Itoa(first + max), nil
+}
+
+// GetAuthzPermissionResources returns the resources a permission applies to.
+func (c *Client) GetAuthzPermissionResources(ctx context.Context, idOfClient, permissionID string) ([]*gocloak.PermissionResource, error) {
+	token, err := c.tokens.TokenContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token: %w", err)
+	}
+
+	return c.client.GetPermissionResources(ctx, token.AccessToken, c.realm, idOfClient, permissionID)
+}
+
+// GetAuthzPermissionScopes returns the scopes a permission applies to.
+func (c *Client) GetAuthzPermissionScopes(ctx context.Context, idOfClient, permissionID string) ([]*gocloak.PermissionScope, error) {
+	token, err := c.tokens.TokenContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token: %w", err)
+	}
+
+	return c.client.GetPermissionScopes(ctx, token.AccessToken, c.realm, idOfClient, permissionID)
+}
+
+// GetAssociatedPolicies returns the policies attached to a permission or an
+// aggregated policy of the client's resource server.
+func (c *Client) GetAssociatedPolicies(ctx context.Context, idOfClient, policyID string) ([]*gocloak.PolicyRepresentation, error) {
+	token, err := c.tokens.TokenContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token: %w", err)
+	}
+
+	return c.client.GetAuthorizationPolicyAssociatedPolicies(ctx, token.AccessToken, c.realm, idOfClient, policyID)
+}
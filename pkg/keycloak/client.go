@@ -2,38 +2,75 @@ package keycloak
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 
-	"github.com/Clarilab/gocloaksession"
 	"github.com/Nerzal/gocloak/v13"
+	"github.com/go-resty/resty/v2"
+	"golang.org/x/oauth2"
 )
 
 type Client struct {
 	client       *gocloak.GoCloak
+	serverURL    string
 	realm        string
+	authRealm    string
 	clientID     string
 	clientSecret string
-	session      gocloaksession.GoCloakSession
+	tokens       *TokenSource
+
+	mu                sync.Mutex
+	realmManagementID string
+
+	// userUpdates serializes the read-modify-write cycles of updateUser.
+	userUpdates sync.Mutex
 }
 
-func NewClient(serverURL, realm, clientID, clientSecret string) (*Client, error) {
-	session, err := gocloaksession.NewSession(clientID, clientSecret, realm, serverURL)
+func NewClient(cfg Config) (*Client, error) {
+	c := &Client{
+		client:       gocloak.NewClient(cfg.ServerURL),
+		serverURL:    strings.TrimRight(cfg.ServerURL, "/"),
+		realm:        cfg.Realm,
+		authRealm:    cfg.AuthRealm,
+		clientID:     cfg.ClientID,
+		clientSecret: cfg.ClientSecret,
+	}
+	if c.authRealm == "" {
+		c.authRealm = c.realm
+	}
+
+	login, err := c.newLogin(cfg)
 	if err != nil {
 		return nil, err
 	}
+	c.tokens = newTokenSource(login)
 
-	return &Client{
-		client:       gocloak.NewClient(serverURL),
-		realm:        realm,
-		clientID:     clientID,
-		clientSecret: clientSecret,
-		session:      session,
-	}, nil
+	base, err := newBaseTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+	c.client.RestyClient().SetTransport(&retryTransport{
+		base: &reauthTransport{
+			base:   newLimitTransport(base, cfg.MaxRequestsPerSecond, cfg.MaxInFlightRequests, cfg.WaitObserver),
+			tokens: c.tokens,
+		},
+	})
+
+	return c, nil
+}
+
+// TokenSource returns the source of the admin API tokens the client uses.
+func (c *Client) TokenSource() oauth2.TokenSource {
+	return c.tokens
 }
 
 func (c *Client) AddUserToGroup(ctx context.Context, userID, groupID string) error {
-	token, err := c.session.GetKeycloakAuthToken()
+	token, err := c.tokens.TokenContext(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get token: %w", err)
 	}
@@ -42,7 +79,7 @@ func (c *Client) AddUserToGroup(ctx context.Context, userID, groupID string) err
 }
 
 func (c *Client) RemoveUserFromGroup(ctx context.Context, userID, groupID string) error {
-	token, err := c.session.GetKeycloakAuthToken()
+	token, err := c.tokens.TokenContext(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get token: %w", err)
 	}
@@ -50,18 +87,95 @@ func (c *Client) RemoveUserFromGroup(ctx context.Context, userID, groupID string
 	return c.client.DeleteUserFromGroup(ctx, token.AccessToken, c.realm, userID, groupID)
 }
 
-func (c *Client) GetUsers(ctx context.Context, first int) ([]*gocloak.User, string, error) {
-	token, err := c.session.GetKeycloakAuthToken()
+// UserQuery filters the users returned by GetUsers. The zero value matches
+// every user.
+type UserQuery struct {
+	// Search is Keycloak's search over username, email, first and last
+	// name. Each whitespace-separated term must match one of them: as a
+	// prefix, with * as a wildcard, e.g. *doe for an infix match, or exactly
+	// when quoted.
+	Search string
+	// Attributes are attribute values users must all have.
+	Attributes map[string]string
+}
+
+// Matches applies the query to a user the way Keycloak does, for users that
+// were read without it, e.g. as group members.
+func (q UserQuery) Matches(user *gocloak.User) bool {
+	for _, term := range strings.Fields(q.Search) {
+		if !searchTermMatches(term, user) {
+			return false
+		}
+	}
+	for key, value := range q.Attributes {
+		if user.Attributes == nil || !slices.Contains((*user.Attributes)[key], value) {
+			return false
+		}
+	}
+	return true
+}
+
+// searchTermMatches matches one search term against the searched fields,
+// ignoring case like Keycloak.
+func searchTermMatches(term string, user *gocloak.User) bool {
+	term = strings.ToLower(term)
+	exact := len(term) >= 2 && strings.HasPrefix(term, `"`) && strings.HasSuffix(term, `"`)
+	for _, field := range []*string{user.Username, user.Email, user.FirstName, user.LastName} {
+		if field == nil {
+			continue
+		}
+		value := strings.ToLower(*field)
+		if exact && value == term[1:len(term)-1] || !exact && wildcardPrefixMatch(term, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcardPrefixMatch reports whether value starts with pattern, each * in it
+// matching any run of characters.
+func wildcardPrefixMatch(pattern, value string) bool {
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(value, parts[0]) {
+		return false
+	}
+	value = value[len(parts[0]):]
+	for _, part := range parts[1:] {
+		i := strings.Index(value, part)
+		if i < 0 {
+			return false
+		}
+		value = value[i+len(part):]
+	}
+	return true
+}
+
+func (c *Client) GetUsers(ctx context.Context, query UserQuery, first int) ([]*gocloak.User, string, error) {
+	token, err := c.tokens.TokenContext(ctx)
 	if err != nil {
 		return nil, strconv.Itoa(first), fmt.Errorf("failed to get token: %w", err)
 	}
 
 	max := 300
 
-	users, err := c.client.GetUsers(ctx, token.AccessToken, c.realm, gocloak.GetUsersParams{
+	params := gocloak.GetUsersParams{
 		First: pointer(first),
 		Max:   pointer(max),
-	})
+	}
+	if query.Search != "" {
+		params.Search = pointer(query.Search)
+	}
+	if len(query.Attributes) > 0 {
+		// q takes space separated key:value pairs.
+		pairs := make([]string, 0, len(query.Attributes))
+		for key, value := range query.Attributes {
+			pairs = append(pairs, key+":"+value)
+		}
+		slices.Sort(pairs)
+		params.Q = pointer(strings.Join(pairs, " "))
+	}
+
+	users, err := c.client.GetUsers(ctx, token.AccessToken, c.realm, params)
 	if err != nil {
 		return nil, strconv.Itoa(first), fmt.Errorf("failed to get users: %w", err)
 	}
@@ -73,17 +187,56 @@ func (c *Client) GetUsers(ctx context.Context, first int) ([]*gocloak.User, stri
 	return users, strconv.Itoa(first + max), nil
 }
 
-func (c *Client) GetGroupMembers(ctx context.Context, groupID string) ([]*gocloak.User, error) {
-	token, err := c.session.GetKeycloakAuthToken()
+func (c *Client) GetUser(ctx context.Context, userID string) (*gocloak.User, error) {
+	token, err := c.tokens.TokenContext(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get token: %w", err)
 	}
 
-	return c.client.GetGroupMembers(ctx, token.AccessToken, c.realm, groupID, gocloak.GetGroupsParams{})
+	return c.client.GetUserByID(ctx, token.AccessToken, c.realm, userID)
+}
+
+// GetSubGroups returns all direct subgroups of the group. gocloak has no call
+// for the children endpoint, which pages like the member listing.
+func (c *Client) GetSubGroups(ctx context.Context, groupID string) ([]*gocloak.Group, error) {
+	return readAll(func(first, max int) ([]*gocloak.Group, error) {
+		token, err := c.tokens.TokenContext(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get token: %w", err)
+		}
+
+		var groups []*gocloak.Group
+		resp, err := c.client.GetRequestWithBearerAuth(ctx, token.AccessToken).
+			SetQueryParams(map[string]string{
+				"first": strconv.Itoa(first),
+				"max":   strconv.Itoa(max),
+			}).
+			SetResult(&groups).
+			Get(c.adminURL("groups", groupID, "children"))
+		if err := checkResponse(resp, err, "failed to get subgroups"); err != nil {
+			return nil, err
+		}
+		return groups, nil
+	})
+}
+
+// GetGroupMembers returns all direct members of the group.
+func (c *Client) GetGroupMembers(ctx context.Context, groupID string) ([]*gocloak.User, error) {
+	return readAll(func(first, max int) ([]*gocloak.User, error) {
+		token, err := c.tokens.TokenContext(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get token: %w", err)
+		}
+
+		return c.client.GetGroupMembers(ctx, token.AccessToken, c.realm, groupID, gocloak.GetGroupsParams{
+			First: pointer(first),
+			Max:   pointer(max),
+		})
+	})
 }
 
 func (c *Client) GetGroups(ctx context.Context, first int) ([]*gocloak.Group, string, error) {
-	token, err := c.session.GetKeycloakAuthToken()
+	token, err := c.tokens.TokenContext(ctx)
 	if err != nil {
 		return nil, strconv.Itoa(first), fmt.Errorf("failed to get token: %w", err)
 	}
@@ -105,19 +258,186 @@ func (c *Client) GetGroups(ctx context.Context, first int) ([]*gocloak.Group, st
 	return groups, strconv.Itoa(first + max), nil
 }
 
-func (c *Client) GetUserGroups(ctx context.Context, userID string) ([]*gocloak.Group, error) {
-	token, err := c.session.GetKeycloakAuthToken()
+func (c *Client) GetGroup(ctx context.Context, groupID string) (*gocloak.Group, error) {
+	token, err := c.tokens.TokenContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token: %w", err)
+	}
+
+	return c.client.GetGroup(ctx, token.AccessToken, c.realm, groupID)
+}
+
+func (c *Client) GetGroupByPath(ctx context.Context, path string) (*gocloak.Group, error) {
+	token, err := c.tokens.TokenContext(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get token: %w", err)
 	}
 
-	return c.client.GetUserGroups(ctx, token.AccessToken, c.realm, userID, gocloak.GetGroupsParams{})
+	// gocloak adds the separator itself.
+	return c.client.GetGroupByPath(ctx, token.AccessToken, c.realm, strings.TrimPrefix(path, "/"))
+}
+
+// GetUserGroups returns every group the user is a direct member of. Keycloak
+// returns the first 100 unless paged.
+func (c *Client) GetUserGroups(ctx context.Context, userID string) ([]*gocloak.Group, error) {
+	return readAll(func(first, max int) ([]*gocloak.Group, error) {
+		token, err := c.tokens.TokenContext(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get token: %w", err)
+		}
+
+		return c.client.GetUserGroups(ctx, token.AccessToken, c.realm, userID, gocloak.GetGroupsParams{
+			First: pointer(first),
+			Max:   pointer(max),
+		})
+	})
+}
+
+func (c *Client) EnableUser(ctx context.Context, userID string) error {
+	return c.setUserEnabled(ctx, userID, true)
+}
+
+func (c *Client) DisableUser(ctx context.Context, userID string) error {
+	return c.setUserEnabled(ctx, userID, false)
+}
+
+// setUserEnabled updates the full user, as UpdateUserAttribute does: with the
+// declarative user profile, a partial representation clears the attributes it
+// leaves out.
+func (c *Client) setUserEnabled(ctx context.Context, userID string, enabled bool) error {
+	return c.updateUser(ctx, userID, func(user *gocloak.User) bool {
+		if user.Enabled != nil && *user.Enabled == enabled {
+			return false
+		}
+		user.Enabled = pointer(enabled)
+		return true
+	})
+}
+
+func (c *Client) UpdateUserAttribute(ctx context.Context, userID, name string, update func(values []string) []string) error {
+	return c.updateUser(ctx, userID, func(user *gocloak.User) bool {
+		attributes := make(map[string][]string)
+		if user.Attributes != nil {
+			for key, value := range *user.Attributes {
+				attributes[key] = value
+			}
+		}
+		current := attributes[name]
+		values := update(slices.Clone(current))
+		if slices.Equal(values, current) {
+			return false
+		}
+		if len(values) == 0 {
+			delete(attributes, name)
+		} else {
+			attributes[name] = values
+		}
+		user.Attributes = &attributes
+		return true
+	})
+}
+
+// updateUser applies change to the user as Keycloak has it now and sends the
+// whole user back, unless change reports nothing changed. Keycloak replaces
+// all attributes with the ones sent, and with the user profile enabled clears
+// omitted fields, so partial updates would lose data. The cycles are
+// serialized so concurrent updates through this client don't overwrite each
+// other; Keycloak has no conditional update to guard against other writers.
+func (c *Client) updateUser(ctx context.Context, userID string, change func(user *gocloak.User) bool) error {
+	c.userUpdates.Lock()
+	defer c.userUpdates.Unlock()
+
+	token, err := c.tokens.TokenContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get token: %w", err)
+	}
+
+	user, err := c.client.GetUserByID(ctx, token.AccessToken, c.realm, userID)
+	if err != nil {
+		return err
+	}
+	if !change(user) {
+		return nil
+	}
+
+	return c.client.UpdateUser(ctx, token.AccessToken, c.realm, *user)
+}
+
+// ClearUserBruteForceLockout removes any temporary or permanent lockout the
+// brute force detector has placed on the user.
+func (c *Client) ClearUserBruteForceLockout(ctx context.Context, userID string) error {
+	token, err := c.tokens.TokenContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get token: %w", err)
+	}
+
+	// gocloak only exposes the status endpoint for brute force detection, so
+	// the delete is issued directly.
+	resp, err := c.client.GetRequestWithBearerAuth(ctx, token.AccessToken).
+		Delete(c.adminURL("attack-detection", "brute-force", "users", userID))
+
+	return checkResponse(resp, err, "failed to clear brute force lockout")
 }
 
 func (c *Client) Close() error {
 	return nil
 }
 
+// IsNotFound reports whether err is Keycloak answering 404 for the requested object.
+func IsNotFound(err error) bool {
+	var apiErr *gocloak.APIError
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+// readAllPageSize is the page size of readAll. Keycloak returns 100 results
+// from its unpaginated-looking listings unless given first and max.
+const readAllPageSize = 100
+
+// readAll reads a listing taking first and max page by page until a short page
+// comes back.
+func readAll[T any](fetch func(first, max int) ([]T, error)) ([]T, error) {
+	var items []T
+	for first := 0; ; first += readAllPageSize {
+		page, err := fetch(first, readAllPageSize)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page...)
+		if len(page) < readAllPageSize {
+			return items, nil
+		}
+	}
+}
+
+// adminURL builds a URL below the admin endpoint of the configured realm.
+func (c *Client) adminURL(path ...string) string {
+	return strings.Join(append([]string{c.serverURL, "admin", "realms", c.realm}, path...), "/")
+}
+
+// checkResponse turns a failed raw request into the same error type gocloak
+// returns, so callers can treat both the same way.
+func checkResponse(resp *resty.Response, err error, msg string) error {
+	if err != nil {
+		return &gocloak.APIError{
+			Message: fmt.Sprintf("%s: %s", msg, err.Error()),
+			Type:    gocloak.ParseAPIErrType(err),
+		}
+	}
+
+	if resp == nil {
+		return &gocloak.APIError{Message: fmt.Sprintf("%s: empty response", msg)}
+	}
+
+	if resp.IsError() {
+		return &gocloak.APIError{
+			Code:    resp.StatusCode(),
+			Message: fmt.Sprintf("%s: %s", msg, resp.Status()),
+		}
+	}
+
+	return nil
+}
+
 func pointer[T any](v T) *T {
 	return &v
 }
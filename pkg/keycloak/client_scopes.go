@@ -0,0 +1,59 @@
+package keycloak
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// GetClientScopes lists every client scope of the realm. Keycloak does not
+// paginate client scopes.
+func (c *Client) GetClientScopes(ctx context.Context) ([]*gocloak.ClientScope, error) {
+	token, err := c.tokens.TokenContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token: %w", err)
+	}
+
+	return c.client.GetClientScopes(ctx, token.AccessToken, c.realm)
+}
+
+// GetRealmDefaultClientScopes returns the client scopes the realm assigns to new
+// clients as default and as optional scopes.
+func (c *Client) GetRealmDefaultClientScopes(ctx context.Context) ([]*gocloak.ClientScope, []*gocloak.ClientScope, error) {
+	token, err := c.tokens.TokenContext(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get token: %w", err)
+	}
+
+	defaultScopes, err := c.client.GetDefaultDefaultClientScopes(ctx, token.AccessToken, c.realm)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get default client scopes: %w", err)
+	}
+
+	optionalScopes, err := c.client.GetDefaultOptionalClientScopes(ctx, token.AccessToken, c.realm)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get optional client scopes: %w", err)
+	}
+
+	return defaultScopes, optionalScopes, nil
+}
+
+// GetClientScopeScopeMappings returns the realm and client roles mapped into a
+// client scope. gocloak only reads these one client at a time.
+func (c *Client) GetClientScopeScopeMappings(ctx context.Context, scopeID string) (*gocloak.MappingsRepresentation, error) {
+	token, err := c.tokens.TokenContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token: %w", err)
+	}
+
+	var mappings gocloak.MappingsRepresentation
+	resp, err := c.client.GetRequestWithBearerAuth(ctx, token.AccessToken).
+		SetResult(&mappings).
+		Get(c.adminURL("client-scopes", scopeID, "scope-mappings"))
+	if err := checkResponse(resp, err, "failed to get client scope mappings"); err != nil {
+		return nil, err
+	}
+
+	return &mappings, nil
+}
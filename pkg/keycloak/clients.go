@@ -0,0 +1,78 @@
+package keycloak
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+func (c *Client) GetClients(ctx context.Context, first int) ([]*gocloak.Client, string, error) {
+	token, err := c.tokens.TokenContext(ctx)
+	if err != nil {
+		return nil, strconv.Itoa(first), fmt.Errorf("failed to get token: %w", err)
+	}
+
+	max := 300
+
+	clients, err := c.client.GetClients(ctx, token.AccessToken, c.realm, gocloak.GetClientsParams{
+		First: pointer(first),
+		Max:   pointer(max),
+	})
+	if err != nil {
+		return nil, strconv.Itoa(first), fmt.Errorf("failed to get clients: %w", err)
+	}
+
+	if len(clients) == 0 {
+		return nil, "", nil
+	}
+
+	return clients, strconv.Itoa(first + max), nil
+}
+
+// GetClientRoles lists the roles defined by a client. idOfClient is the client's
+// internal ID, not its clientId.
+func (c *Client) GetClientRoles(ctx context.Context, idOfClient string, first int) ([]*gocloak.Role, string, error) {
+	token, err := c.tokens.TokenContext(ctx)
+	if err != nil {
+		return nil, strconv.Itoa(first), fmt.Errorf("failed to get token: %w", err)
+	}
+
+	max := 300
+
+	roles, err := c.client.GetClientRoles(ctx, token.AccessToken, c.realm, idOfClient, gocloak.GetRoleParams{
+		First: pointer(first),
+		Max:   pointer(max),
+	})
+	if err != nil {
+		return nil, strconv.Itoa(first), fmt.Errorf("failed to get client roles: %w", err)
+	}
+
+	if len(roles) == 0 {
+		return nil, "", nil
+	}
+
+	return roles, strconv.Itoa(first + max), nil
+}
+
+// GetClient fetches a single client by its internal ID.
+func (c *Client) GetClient(ctx context.Context, idOfClient string) (*gocloak.Client, error) {
+	token, err := c.tokens.TokenContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token: %w", err)
+	}
+
+	return c.client.GetClient(ctx, token.AccessToken, c.realm, idOfClient)
+}
+
+// GetClientServiceAccount returns the user a client authenticates as with the
+// client credentials grant.
+func (c *Client) GetClientServiceAccount(ctx context.Context, idOfClient string) (*gocloak.User, error) {
+	token, err := c.tokens.TokenContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token: %w", err)
+	}
+
+	return c.client.GetClientServiceAccount(ctx, token.AccessToken, c.realm, idOfClient)
+}
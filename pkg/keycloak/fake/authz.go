@@ -0,0 +1,211 @@
+package fake
+
+import (
+	"context"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+)
+
+// authzOrder is the listing order of a client's Authorization Services objects.
+type authzOrder struct {
+	resources   []string
+	scopes      []string
+	permissions []string
+}
+
+// AddAuthzScope stores a scope of the client's resource server and returns its ID.
+func (k *Keycloak) AddAuthzScope(idOfClient string, scope gocloak.ScopeRepresentation) string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	id := k.id(scope.ID, "authz-scope")
+	scope.ID = &id
+	k.authzScopes[id] = &scope
+	k.order(idOfClient).scopes = append(k.order(idOfClient).scopes, id)
+	return id
+}
+
+// AddAuthzResource stores a resource of the client's resource server and
+// returns its ID. Its scopes are referenced by name.
+func (k *Keycloak) AddAuthzResource(idOfClient string, resource gocloak.ResourceRepresentation) string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	id := k.id(resource.ID, "authz-resource")
+	resource.ID = &id
+	k.authzResources[id] = &resource
+	k.order(idOfClient).resources = append(k.order(idOfClient).resources, id)
+	return id
+}
+
+// AddAuthzPolicy stores a user, group, role or aggregate policy and returns its
+// ID. associated are the policies an aggregate policy combines.
+func (k *Keycloak) AddAuthzPolicy(policy gocloak.PolicyRepresentation, associated ...string) string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	id := k.id(policy.ID, "policy")
+	policy.ID = &id
+	k.authzPolicies[id] = &policy
+	k.associatedPolicies[id] = associated
+	return id
+}
+
+// AddAuthzPermission stores a resource or scope based permission of the client
+// naming resourceIDs and scopes, decided by policies, and returns its ID.
+func (k *Keycloak) AddAuthzPermission(idOfClient string, permission gocloak.PermissionRepresentation, resourceIDs, scopes, policies []string) string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	id := k.id(permission.ID, "permission")
+	permission.ID = &id
+	k.authzPermissions[id] = &permission
+	k.order(idOfClient).permissions = append(k.order(idOfClient).permissions, id)
+	k.permissionResources[id] = resourceIDs
+	k.permissionScopes[id] = scopes
+	k.associatedPolicies[id] = policies
+	return id
+}
+
+// SetManagementPermissions enables fine-grained admin permissions on a group
+// or client. scopePermissions maps scopes such as manage-members to
+// permissions of the realm-management client.
+func (k *Keycloak) SetManagementPermissions(id string, scopePermissions map[string]string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.managementPermissions[id] = scopePermissions
+}
+
+func (k *Keycloak) GetGroupManagementPermissions(ctx context.Context, groupID string) (*gocloak.ManagementPermissionRepresentation, error) {
+	return k.getManagementPermissions(groupID), nil
+}
+
+func (k *Keycloak) GetClientManagementPermissions(ctx context.Context, idOfClient string) (*gocloak.ManagementPermissionRepresentation, error) {
+	return k.getManagementPermissions(idOfClient), nil
+}
+
+func (k *Keycloak) getManagementPermissions(id string) *gocloak.ManagementPermissionRepresentation {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	scopePermissions, ok := k.managementPermissions[id]
+	if !ok {
+		return &gocloak.ManagementPermissionRepresentation{Enabled: gocloak.BoolP(false)}
+	}
+
+	copied := make(map[string]string, len(scopePermissions))
+	for scope, permissionID := range scopePermissions {
+		copied[scope] = permissionID
+	}
+	return &gocloak.ManagementPermissionRepresentation{
+		Enabled:          gocloak.BoolP(true),
+		ScopePermissions: &copied,
+	}
+}
+
+func (k *Keycloak) RealmManagementClient(ctx context.Context) (string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for _, id := range k.clientOrder {
+		if gocloak.PString(k.clients[id].ClientID) == keycloak.RealmManagementClientID {
+			return id, nil
+		}
+	}
+	return "", notFound("client", keycloak.RealmManagementClientID)
+}
+
+// GetAuthzResources returns the resources with their scopes, like the deep listing.
+func (k *Keycloak) GetAuthzResources(ctx context.Context, idOfClient string, first int) ([]*gocloak.ResourceRepresentation, string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	ids, nextToken := page(k.order(idOfClient).resources, first, k.PageSize)
+	resources := make([]*gocloak.ResourceRepresentation, 0, len(ids))
+	for _, id := range ids {
+		resources = append(resources, clone(k.authzResources[id]))
+	}
+	return resources, nextToken, nil
+}
+
+func (k *Keycloak) GetAuthzScopes(ctx context.Context, idOfClient string, first int) ([]*gocloak.ScopeRepresentation, string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	ids, nextToken := page(k.order(idOfClient).scopes, first, k.PageSize)
+	scopes := make([]*gocloak.ScopeRepresentation, 0, len(ids))
+	for _, id := range ids {
+		scopes = append(scopes, clone(k.authzScopes[id]))
+	}
+	return scopes, nextToken, nil
+}
+
+func (k *Keycloak) GetAuthzPermissions(ctx context.Context, idOfClient string, first int) ([]*gocloak.PermissionRepresentation, string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	ids, nextToken := page(k.order(idOfClient).permissions, first, k.PageSize)
+	permissions := make([]*gocloak.PermissionRepresentation, 0, len(ids))
+	for _, id := range ids {
+		permissions = append(permissions, clone(k.authzPermissions[id]))
+	}
+	return permissions, nextToken, nil
+}
+
+func (k *Keycloak) GetAuthzPermissionResources(ctx context.Context, idOfClient, permissionID string) ([]*gocloak.PermissionResource, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.authzPermissions[permissionID]; !ok {
+		return nil, notFound("permission", permissionID)
+	}
+
+	resources := make([]*gocloak.PermissionResource, 0, len(k.permissionResources[permissionID]))
+	for _, id := range k.permissionResources[permissionID] {
+		resources = append(resources, &gocloak.PermissionResource{
+			ResourceID:   gocloak.StringP(id),
+			ResourceName: k.authzResources[id].Name,
+		})
+	}
+	return resources, nil
+}
+
+func (k *Keycloak) GetAuthzPermissionScopes(ctx context.Context, idOfClient, permissionID string) ([]*gocloak.PermissionScope, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.authzPermissions[permissionID]; !ok {
+		return nil, notFound("permission", permissionID)
+	}
+
+	scopes := make([]*gocloak.PermissionScope, 0, len(k.permissionScopes[permissionID]))
+	for _, name := range k.permissionScopes[permissionID] {
+		scopes = append(scopes, &gocloak.PermissionScope{ScopeName: gocloak.StringP(name)})
+	}
+	return scopes, nil
+}
+
+func (k *Keycloak) GetAssociatedPolicies(ctx context.Context, idOfClient, policyID string) ([]*gocloak.PolicyRepresentation, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	associated, ok := k.associatedPolicies[policyID]
+	if !ok {
+		return nil, notFound("policy", policyID)
+	}
+
+	policies := make([]*gocloak.PolicyRepresentation, 0, len(associated))
+	for _, id := range associated {
+		policies = append(policies, clone(k.authzPolicies[id]))
+	}
+	return policies, nil
+}
+
+func (k *Keycloak) order(idOfClient string) *authzOrder {
+	if k.authzOrder[idOfClient] == nil {
+		k.authzOrder[idOfClient] = &authzOrder{}
+	}
+	return k.authzOrder[idOfClient]
+}
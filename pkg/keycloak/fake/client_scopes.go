@@ -0,0 +1,68 @@
+package fake
+
+import (
+	"context"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// AddClientScope stores a client scope and returns its ID. realmDefault is
+// "default" or "optional" to make the realm hand the scope to new clients, or
+// empty.
+func (k *Keycloak) AddClientScope(scope gocloak.ClientScope, realmDefault string) string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	id := k.id(scope.ID, "client-scope")
+	scope.ID = &id
+	k.clientScopes[id] = &scope
+	k.clientScopeOrder = append(k.clientScopeOrder, id)
+	if realmDefault != "" {
+		k.realmDefaultScopes[id] = realmDefault
+	}
+	return id
+}
+
+// MapClientScopeRole lets a role into the tokens the client scope is part of.
+func (k *Keycloak) MapClientScopeRole(scopeID, roleID string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	addToSet(k.scopeRoles, scopeID, roleID)
+}
+
+func (k *Keycloak) GetClientScopes(ctx context.Context) ([]*gocloak.ClientScope, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.clientScopeList(k.clientScopeOrder, ""), nil
+}
+
+func (k *Keycloak) GetRealmDefaultClientScopes(ctx context.Context) ([]*gocloak.ClientScope, []*gocloak.ClientScope, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.clientScopeList(k.clientScopeOrder, "default"), k.clientScopeList(k.clientScopeOrder, "optional"), nil
+}
+
+func (k *Keycloak) GetClientScopeScopeMappings(ctx context.Context, scopeID string) (*gocloak.MappingsRepresentation, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.clientScopes[scopeID]; !ok {
+		return nil, notFound("client scope", scopeID)
+	}
+	return k.roleMappings(k.scopeRoles[scopeID]), nil
+}
+
+// clientScopeList returns the scopes with the realm default, or all of them
+// when realmDefault is empty.
+func (k *Keycloak) clientScopeList(ids []string, realmDefault string) []*gocloak.ClientScope {
+	scopes := make([]*gocloak.ClientScope, 0, len(ids))
+	for _, id := range ids {
+		if realmDefault == "" || k.realmDefaultScopes[id] == realmDefault {
+			scopes = append(scopes, clone(k.clientScopes[id]))
+		}
+	}
+	return scopes
+}
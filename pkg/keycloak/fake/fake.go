@@ -0,0 +1,864 @@
+// Package fake is an in-memory Keycloak realm implementing keycloak.API, so
+// the connector can be tested without a server. It models users, groups,
+// roles, clients, memberships, role mappings and events, and paginates like
+// the real client, along with client scopes, Authorization Services and
+// fine-grained admin permissions. Server serves the same realm over Keycloak's
+// HTTP API for end-to-end tests of keycloak.Client and the connector.
+package fake
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+)
+
+// defaultPageSize matches the page size of keycloak.Client.
+const defaultPageSize = 300
+
+// Keycloak is a single in-memory realm. Seed it with the Add and Map methods,
+// then hand it to the code under test as a keycloak.API.
+type Keycloak struct {
+	// PageSize is the size of the paginated listings.
+	PageSize int
+	// ProfileAttributes, when set, are the only user attributes kept, as with
+	// the declarative user profile and unmanaged attributes disabled. Keycloak
+	// drops any other attribute without an error.
+	ProfileAttributes []string
+
+	mu     sync.Mutex
+	realm  string
+	nextID int
+	// settings holds the realm settings set with UpdateRealm.
+	settings gocloak.RealmRepresentation
+
+	users      map[string]*gocloak.User
+	userOrder  []string
+	groups     map[string]*gocloak.Group
+	groupOrder []string
+	// groupParents maps subgroup IDs to their parent.
+	groupParents map[string]string
+	clients      map[string]*gocloak.Client
+	clientOrder  []string
+	roles        map[string]*gocloak.Role
+	// roleOrder holds realm roles under "" and client roles under their client.
+	roleOrder map[string][]string
+
+	// members maps group IDs to the set of their members.
+	members map[string]map[string]bool
+	// userRoles and groupRoles map principals to their directly mapped roles.
+	userRoles  map[string]map[string]bool
+	groupRoles map[string]map[string]bool
+	composites map[string][]string
+	// serviceAccounts maps client IDs to their service-account user.
+	serviceAccounts map[string]string
+
+	defaultRole   string
+	defaultGroups []string
+	userEvents    []*gocloak.EventRepresentation
+	adminEvents   []*keycloak.AdminEvent
+
+	clientScopes     map[string]*gocloak.ClientScope
+	clientScopeOrder []string
+	// realmDefaultScopes maps client scope IDs to "default" or "optional".
+	realmDefaultScopes map[string]string
+	scopeRoles         map[string]map[string]bool
+
+	// The Authorization Services objects are ordered per client.
+	authzResources   map[string]*gocloak.ResourceRepresentation
+	authzScopes      map[string]*gocloak.ScopeRepresentation
+	authzPermissions map[string]*gocloak.PermissionRepresentation
+	authzPolicies    map[string]*gocloak.PolicyRepresentation
+	authzOrder       map[string]*authzOrder
+	// permissionResources and permissionScopes map permission IDs to the
+	// resource IDs and scope names they name, associatedPolicies maps
+	// permissions and aggregate policies to their policies.
+	permissionResources map[string][]string
+	permissionScopes    map[string][]string
+	associatedPolicies  map[string][]string
+	// managementPermissions maps group and client IDs to their enabled admin
+	// permission scopes and the permissions behind them.
+	managementPermissions map[string]map[string]string
+}
+
+var _ keycloak.API = (*Keycloak)(nil)
+
+// New returns an empty realm.
+func New(realm string) *Keycloak {
+	return &Keycloak{
+		PageSize:        defaultPageSize,
+		realm:           realm,
+		users:           make(map[string]*gocloak.User),
+		groups:          make(map[string]*gocloak.Group),
+		groupParents:    make(map[string]string),
+		clients:         make(map[string]*gocloak.Client),
+		roles:           make(map[string]*gocloak.Role),
+		roleOrder:       make(map[string][]string),
+		members:         make(map[string]map[string]bool),
+		userRoles:       make(map[string]map[string]bool),
+		groupRoles:      make(map[string]map[string]bool),
+		composites:      make(map[string][]string),
+		serviceAccounts: make(map[string]string),
+
+		clientScopes:       make(map[string]*gocloak.ClientScope),
+		realmDefaultScopes: make(map[string]string),
+		scopeRoles:         make(map[string]map[string]bool),
+
+		authzResources:        make(map[string]*gocloak.ResourceRepresentation),
+		authzScopes:           make(map[string]*gocloak.ScopeRepresentation),
+		authzPermissions:      make(map[string]*gocloak.PermissionRepresentation),
+		authzPolicies:         make(map[string]*gocloak.PolicyRepresentation),
+		authzOrder:            make(map[string]*authzOrder),
+		permissionResources:   make(map[string][]string),
+		permissionScopes:      make(map[string][]string),
+		associatedPolicies:    make(map[string][]string),
+		managementPermissions: make(map[string]map[string]string),
+	}
+}
+
+// AddUser stores a user and returns its ID, generated unless set. Users are
+// enabled unless Enabled says otherwise.
+func (k *Keycloak) AddUser(user gocloak.User) string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	id := k.id(user.ID, "user")
+	user.ID = &id
+	if user.Enabled == nil {
+		user.Enabled = gocloak.BoolP(true)
+	}
+	k.users[id] = &user
+	k.userOrder = append(k.userOrder, id)
+	return id
+}
+
+// AddGroup stores a group below parentID, or at the top level when parentID is
+// empty, and returns its ID.
+func (k *Keycloak) AddGroup(group gocloak.Group, parentID string) string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	id := k.id(group.ID, "group")
+	group.ID = &id
+
+	path := "/" + gocloak.PString(group.Name)
+	if parent, ok := k.groups[parentID]; ok {
+		path = gocloak.PString(parent.Path) + path
+		k.groupParents[id] = parentID
+	}
+	group.Path = &path
+
+	k.groups[id] = &group
+	k.groupOrder = append(k.groupOrder, id)
+	return id
+}
+
+// MoveGroup moves a group and its subgroups below parentID, or to the top
+// level when parentID is empty.
+func (k *Keycloak) MoveGroup(groupID, parentID string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	delete(k.groupParents, groupID)
+	path := ""
+	if parent, ok := k.groups[parentID]; ok {
+		path = gocloak.PString(parent.Path)
+		k.groupParents[groupID] = parentID
+	}
+	k.setGroupPath(groupID, path+"/"+gocloak.PString(k.groups[groupID].Name))
+}
+
+// setGroupPath sets the path of a group and of the subgroups below it.
+func (k *Keycloak) setGroupPath(groupID, path string) {
+	k.groups[groupID].Path = &path
+	for childID, parentID := range k.groupParents {
+		if parentID == groupID {
+			k.setGroupPath(childID, path+"/"+gocloak.PString(k.groups[childID].Name))
+		}
+	}
+}
+
+// UpdateRealm changes the realm settings GetRealm reports, such as whether
+// events are recorded and for how long.
+func (k *Keycloak) UpdateRealm(update func(realm *gocloak.RealmRepresentation)) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	update(&k.settings)
+}
+
+// AddClient stores a client and returns its internal ID.
+func (k *Keycloak) AddClient(client gocloak.Client) string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	id := k.id(client.ID, "client")
+	client.ID = &id
+	k.clients[id] = &client
+	k.clientOrder = append(k.clientOrder, id)
+	return id
+}
+
+// AddRealmRole stores a realm role and returns its ID.
+func (k *Keycloak) AddRealmRole(role gocloak.Role) string {
+	return k.addRole(role, "")
+}
+
+// AddClientRole stores a role of the client and returns its ID.
+func (k *Keycloak) AddClientRole(idOfClient string, role gocloak.Role) string {
+	return k.addRole(role, idOfClient)
+}
+
+func (k *Keycloak) addRole(role gocloak.Role, idOfClient string) string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	id := k.id(role.ID, "role")
+	role.ID = &id
+	role.ClientRole = gocloak.BoolP(idOfClient != "")
+	if idOfClient != "" {
+		role.ContainerID = &idOfClient
+	} else {
+		role.ContainerID = &k.realm
+	}
+	k.roles[id] = &role
+	k.roleOrder[idOfClient] = append(k.roleOrder[idOfClient], id)
+	return id
+}
+
+// MapUserRole maps a role directly to a user.
+func (k *Keycloak) MapUserRole(userID, roleID string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	addToSet(k.userRoles, userID, roleID)
+}
+
+// MapGroupRole maps a role to a group.
+func (k *Keycloak) MapGroupRole(groupID, roleID string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	addToSet(k.groupRoles, groupID, roleID)
+}
+
+// AddComposite makes roleID a composite role containing childID.
+func (k *Keycloak) AddComposite(roleID, childID string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.composites[roleID] = append(k.composites[roleID], childID)
+	if role, ok := k.roles[roleID]; ok {
+		role.Composite = gocloak.BoolP(true)
+	}
+}
+
+// SetDefaultRole makes roleID the realm's default role.
+func (k *Keycloak) SetDefaultRole(roleID string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.defaultRole = roleID
+}
+
+// AddDefaultGroup makes every new user join groupID.
+func (k *Keycloak) AddDefaultGroup(groupID string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.defaultGroups = append(k.defaultGroups, groupID)
+}
+
+// SetServiceAccount makes userID the service account of the client.
+func (k *Keycloak) SetServiceAccount(idOfClient, userID string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.serviceAccounts[idOfClient] = userID
+	if user, ok := k.users[userID]; ok {
+		user.ServiceAccountClientID = k.clients[idOfClient].ClientID
+	}
+}
+
+// AddUserEvent records a user event such as a LOGIN.
+func (k *Keycloak) AddUserEvent(event gocloak.EventRepresentation) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.userEvents = append(k.userEvents, &event)
+}
+
+// AddAdminEvent records an admin event.
+func (k *Keycloak) AddAdminEvent(event keycloak.AdminEvent) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.adminEvents = append(k.adminEvents, &event)
+}
+
+// GetUsers leaves out service accounts, as Keycloak does.
+func (k *Keycloak) GetUsers(ctx context.Context, query keycloak.UserQuery, first int) ([]*gocloak.User, string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	var listed []string
+	for _, userID := range k.userOrder {
+		user := k.users[userID]
+		if user.ServiceAccountClientID == nil && query.Matches(user) {
+			listed = append(listed, userID)
+		}
+	}
+
+	users, nextToken := page(listed, first, k.PageSize)
+	return k.userList(users), nextToken, nil
+}
+
+func (k *Keycloak) GetUser(ctx context.Context, userID string) (*gocloak.User, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	user, ok := k.users[userID]
+	if !ok {
+		return nil, notFound("user", userID)
+	}
+	return clone(user), nil
+}
+
+func (k *Keycloak) GetUserGroups(ctx context.Context, userID string) ([]*gocloak.Group, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.users[userID]; !ok {
+		return nil, notFound("user", userID)
+	}
+
+	var groups []*gocloak.Group
+	for _, groupID := range k.groupOrder {
+		if k.members[groupID][userID] {
+			groups = append(groups, clone(k.groups[groupID]))
+		}
+	}
+	return groups, nil
+}
+
+func (k *Keycloak) EnableUser(ctx context.Context, userID string) error {
+	return k.setUserEnabled(userID, true)
+}
+
+func (k *Keycloak) DisableUser(ctx context.Context, userID string) error {
+	return k.setUserEnabled(userID, false)
+}
+
+func (k *Keycloak) setUserEnabled(userID string, enabled bool) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	user, ok := k.users[userID]
+	if !ok {
+		return notFound("user", userID)
+	}
+	user.Enabled = gocloak.BoolP(enabled)
+	return nil
+}
+
+func (k *Keycloak) UpdateUserAttribute(ctx context.Context, userID, name string, update func(values []string) []string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	user, ok := k.users[userID]
+	if !ok {
+		return notFound("user", userID)
+	}
+	attributes := make(map[string][]string)
+	if user.Attributes != nil {
+		for key, value := range *user.Attributes {
+			attributes[key] = value
+		}
+	}
+	values := update(slices.Clone(attributes[name]))
+	if len(values) == 0 {
+		delete(attributes, name)
+	} else {
+		attributes[name] = append([]string(nil), values...)
+	}
+	user.Attributes = k.profileAttributes(attributes)
+	return nil
+}
+
+// profileAttributes drops the attributes the user profile doesn't allow.
+func (k *Keycloak) profileAttributes(attributes map[string][]string) *map[string][]string {
+	if k.ProfileAttributes != nil {
+		maps.DeleteFunc(attributes, func(name string, _ []string) bool {
+			return !slices.Contains(k.ProfileAttributes, name)
+		})
+	}
+	return &attributes
+}
+
+// replaceUserAttributes stores attributes as the user's only attributes, like
+// a user update carrying them.
+func (k *Keycloak) replaceUserAttributes(userID string, attributes map[string][]string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	user, ok := k.users[userID]
+	if !ok {
+		return notFound("user", userID)
+	}
+	copied := make(map[string][]string, len(attributes))
+	for key, value := range attributes {
+		copied[key] = append([]string(nil), value...)
+	}
+	user.Attributes = k.profileAttributes(copied)
+	return nil
+}
+
+func (k *Keycloak) ClearUserBruteForceLockout(ctx context.Context, userID string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.users[userID]; !ok {
+		return notFound("user", userID)
+	}
+	return nil
+}
+
+// GetGroups lists the top-level groups, as Keycloak does.
+func (k *Keycloak) GetGroups(ctx context.Context, first int) ([]*gocloak.Group, string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	var topLevel []string
+	for _, groupID := range k.groupOrder {
+		if _, ok := k.groupParents[groupID]; !ok {
+			topLevel = append(topLevel, groupID)
+		}
+	}
+
+	groups, nextToken := page(topLevel, first, k.PageSize)
+	return k.groupList(groups), nextToken, nil
+}
+
+func (k *Keycloak) GetGroup(ctx context.Context, groupID string) (*gocloak.Group, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	group, ok := k.groups[groupID]
+	if !ok {
+		return nil, notFound("group", groupID)
+	}
+	return clone(group), nil
+}
+
+func (k *Keycloak) GetGroupByPath(ctx context.Context, path string) (*gocloak.Group, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for _, groupID := range k.groupOrder {
+		if group := k.groups[groupID]; gocloak.PString(group.Path) == path {
+			return clone(group), nil
+		}
+	}
+	return nil, notFound("group", path)
+}
+
+func (k *Keycloak) GetSubGroups(ctx context.Context, groupID string) ([]*gocloak.Group, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.groups[groupID]; !ok {
+		return nil, notFound("group", groupID)
+	}
+
+	var children []string
+	for _, id := range k.groupOrder {
+		if k.groupParents[id] == groupID {
+			children = append(children, id)
+		}
+	}
+	return k.groupList(children), nil
+}
+
+func (k *Keycloak) GetGroupMembers(ctx context.Context, groupID string) ([]*gocloak.User, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.groups[groupID]; !ok {
+		return nil, notFound("group", groupID)
+	}
+
+	var members []string
+	for _, userID := range k.userOrder {
+		if k.members[groupID][userID] {
+			members = append(members, userID)
+		}
+	}
+	return k.userList(members), nil
+}
+
+// AddUserToGroup is idempotent, like Keycloak.
+func (k *Keycloak) AddUserToGroup(ctx context.Context, userID, groupID string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.users[userID]; !ok {
+		return notFound("user", userID)
+	}
+	if _, ok := k.groups[groupID]; !ok {
+		return notFound("group", groupID)
+	}
+
+	addToSet(k.members, groupID, userID)
+	return nil
+}
+
+// RemoveUserFromGroup succeeds for users who are not members, like Keycloak.
+func (k *Keycloak) RemoveUserFromGroup(ctx context.Context, userID, groupID string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.users[userID]; !ok {
+		return notFound("user", userID)
+	}
+	if _, ok := k.groups[groupID]; !ok {
+		return notFound("group", groupID)
+	}
+
+	delete(k.members[groupID], userID)
+	return nil
+}
+
+func (k *Keycloak) GetRealm(ctx context.Context) (*gocloak.RealmRepresentation, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	realm := clone(&k.settings)
+	realm.ID = gocloak.StringP(k.realm)
+	realm.Realm = gocloak.StringP(k.realm)
+	realm.Enabled = gocloak.BoolP(true)
+	if role, ok := k.roles[k.defaultRole]; ok {
+		realm.DefaultRole = clone(role)
+	}
+	return realm, nil
+}
+
+func (k *Keycloak) GetDefaultGroups(ctx context.Context) ([]*gocloak.Group, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.groupList(k.defaultGroups), nil
+}
+
+func (k *Keycloak) GetRealmRoles(ctx context.Context, first int) ([]*gocloak.Role, string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	roles, nextToken := page(k.roleOrder[""], first, k.PageSize)
+	return k.roleList(roles), nextToken, nil
+}
+
+func (k *Keycloak) GetRealmRoleUsers(ctx context.Context, roleName string, first int) ([]*gocloak.User, string, error) {
+	return k.roleUsers("", roleName, first)
+}
+
+func (k *Keycloak) GetRealmRoleGroups(ctx context.Context, roleName string) ([]*gocloak.Group, error) {
+	return k.roleGroups("", roleName)
+}
+
+func (k *Keycloak) GetClientRoleUsers(ctx context.Context, idOfClient, roleName string, first int) ([]*gocloak.User, string, error) {
+	return k.roleUsers(idOfClient, roleName, first)
+}
+
+func (k *Keycloak) GetClientRoleGroups(ctx context.Context, idOfClient, roleName string) ([]*gocloak.Group, error) {
+	return k.roleGroups(idOfClient, roleName)
+}
+
+func (k *Keycloak) roleUsers(idOfClient, roleName string, first int) ([]*gocloak.User, string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	roleID, ok := k.roleByName(idOfClient, roleName)
+	if !ok {
+		return nil, "", notFound("role", roleName)
+	}
+
+	var holders []string
+	for _, userID := range k.userOrder {
+		if k.userRoles[userID][roleID] {
+			holders = append(holders, userID)
+		}
+	}
+
+	users, nextToken := page(holders, first, k.PageSize)
+	return k.userList(users), nextToken, nil
+}
+
+func (k *Keycloak) roleGroups(idOfClient, roleName string) ([]*gocloak.Group, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	roleID, ok := k.roleByName(idOfClient, roleName)
+	if !ok {
+		return nil, notFound("role", roleName)
+	}
+
+	var holders []string
+	for _, groupID := range k.groupOrder {
+		if k.groupRoles[groupID][roleID] {
+			holders = append(holders, groupID)
+		}
+	}
+	return k.groupList(holders), nil
+}
+
+func (k *Keycloak) GetRoleComposites(ctx context.Context, roleID string) ([]*gocloak.Role, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.roles[roleID]; !ok {
+		return nil, notFound("role", roleID)
+	}
+	return k.roleList(k.composites[roleID]), nil
+}
+
+func (k *Keycloak) GetUserRoleMappings(ctx context.Context, userID string) (*gocloak.MappingsRepresentation, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.users[userID]; !ok {
+		return nil, notFound("user", userID)
+	}
+
+	return k.roleMappings(k.userRoles[userID]), nil
+}
+
+func (k *Keycloak) GetGroupRoleMappings(ctx context.Context, groupID string) (*gocloak.MappingsRepresentation, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.groups[groupID]; !ok {
+		return nil, notFound("group", groupID)
+	}
+
+	return k.roleMappings(k.groupRoles[groupID]), nil
+}
+
+func (k *Keycloak) GetClients(ctx context.Context, first int) ([]*gocloak.Client, string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	ids, nextToken := page(k.clientOrder, first, k.PageSize)
+	clients := make([]*gocloak.Client, 0, len(ids))
+	for _, id := range ids {
+		clients = append(clients, clone(k.clients[id]))
+	}
+	return clients, nextToken, nil
+}
+
+func (k *Keycloak) GetClient(ctx context.Context, idOfClient string) (*gocloak.Client, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	client, ok := k.clients[idOfClient]
+	if !ok {
+		return nil, notFound("client", idOfClient)
+	}
+	return clone(client), nil
+}
+
+func (k *Keycloak) GetClientRoles(ctx context.Context, idOfClient string, first int) ([]*gocloak.Role, string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.clients[idOfClient]; !ok {
+		return nil, "", notFound("client", idOfClient)
+	}
+
+	roles, nextToken := page(k.roleOrder[idOfClient], first, k.PageSize)
+	return k.roleList(roles), nextToken, nil
+}
+
+func (k *Keycloak) GetClientServiceAccount(ctx context.Context, idOfClient string) (*gocloak.User, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	userID, ok := k.serviceAccounts[idOfClient]
+	if !ok {
+		return nil, notFound("service account of client", idOfClient)
+	}
+	return clone(k.users[userID]), nil
+}
+
+// GetAdminEvents returns the recorded admin events newest first.
+func (k *Keycloak) GetAdminEvents(ctx context.Context, params keycloak.GetAdminEventsParams) ([]*keycloak.AdminEvent, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	var events []*keycloak.AdminEvent
+	for _, event := range k.adminEvents {
+		if event.OccurredAt().Before(params.DateFrom) {
+			continue
+		}
+		if len(params.ResourceTypes) > 0 && !slices.Contains(params.ResourceTypes, event.ResourceType) {
+			continue
+		}
+		copied := *event
+		events = append(events, &copied)
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time > events[j].Time })
+
+	return window(events, params.First, params.Max), nil
+}
+
+// GetUserEvents returns the recorded user events newest first.
+func (k *Keycloak) GetUserEvents(ctx context.Context, eventTypes []string, dateFrom time.Time, first, max int) ([]*gocloak.EventRepresentation, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	var events []*gocloak.EventRepresentation
+	for _, event := range k.userEvents {
+		if time.UnixMilli(event.Time).Before(dateFrom) {
+			continue
+		}
+		if len(eventTypes) > 0 && !slices.Contains(eventTypes, gocloak.PString(event.Type)) {
+			continue
+		}
+		events = append(events, clone(event))
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time > events[j].Time })
+
+	return window(events, first, max), nil
+}
+
+func (k *Keycloak) Close() error {
+	return nil
+}
+
+// roleMappings groups a set of role IDs into realm and per-client mappings.
+func (k *Keycloak) roleMappings(roleIDs map[string]bool) *gocloak.MappingsRepresentation {
+	var realmMappings []gocloak.Role
+	clientMappings := make(map[string]*gocloak.ClientMappingsRepresentation)
+	for _, roleID := range sortedKeys(roleIDs) {
+		role := *k.roles[roleID]
+		if !gocloak.PBool(role.ClientRole) {
+			realmMappings = append(realmMappings, role)
+			continue
+		}
+
+		client := k.clients[gocloak.PString(role.ContainerID)]
+		clientID := gocloak.PString(client.ClientID)
+		if clientMappings[clientID] == nil {
+			clientMappings[clientID] = &gocloak.ClientMappingsRepresentation{
+				ID:       client.ID,
+				Client:   client.ClientID,
+				Mappings: &[]gocloak.Role{},
+			}
+		}
+		*clientMappings[clientID].Mappings = append(*clientMappings[clientID].Mappings, role)
+	}
+
+	mappings := &gocloak.MappingsRepresentation{ClientMappings: clientMappings}
+	if len(realmMappings) > 0 {
+		mappings.RealmMappings = &realmMappings
+	}
+	return mappings
+}
+
+// id returns the requested ID or generates one like "user-3".
+func (k *Keycloak) id(requested *string, kind string) string {
+	if requested != nil && *requested != "" {
+		return *requested
+	}
+	k.nextID++
+	return fmt.Sprintf("%s-%d", kind, k.nextID)
+}
+
+func (k *Keycloak) roleByName(idOfClient, name string) (string, bool) {
+	for _, roleID := range k.roleOrder[idOfClient] {
+		if gocloak.PString(k.roles[roleID].Name) == name {
+			return roleID, true
+		}
+	}
+	return "", false
+}
+
+func (k *Keycloak) userList(ids []string) []*gocloak.User {
+	users := make([]*gocloak.User, 0, len(ids))
+	for _, id := range ids {
+		users = append(users, clone(k.users[id]))
+	}
+	return users
+}
+
+func (k *Keycloak) groupList(ids []string) []*gocloak.Group {
+	groups := make([]*gocloak.Group, 0, len(ids))
+	for _, id := range ids {
+		groups = append(groups, clone(k.groups[id]))
+	}
+	return groups
+}
+
+func (k *Keycloak) roleList(ids []string) []*gocloak.Role {
+	roles := make([]*gocloak.Role, 0, len(ids))
+	for _, id := range ids {
+		roles = append(roles, clone(k.roles[id]))
+	}
+	return roles
+}
+
+// page cuts a listing the way keycloak.Client does: the next token is the next
+// offset, and an empty page ends the listing with an empty token.
+func page(ids []string, first, size int) ([]string, string) {
+	if first >= len(ids) {
+		return nil, ""
+	}
+	return ids[first:min(first+size, len(ids))], strconv.Itoa(first + size)
+}
+
+// window is first/max pagination of endpoints taking both.
+func window[T any](items []T, first, max int) []T {
+	if first >= len(items) {
+		return nil
+	}
+	end := len(items)
+	if max > 0 {
+		end = min(first+max, end)
+	}
+	return items[first:end]
+}
+
+func notFound(kind, id string) error {
+	return &gocloak.APIError{
+		Code:    http.StatusNotFound,
+		Message: fmt.Sprintf("%s %s not found", kind, id),
+	}
+}
+
+func addToSet(sets map[string]map[string]bool, key, value string) {
+	if sets[key] == nil {
+		sets[key] = make(map[string]bool)
+	}
+	sets[key][value] = true
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func clone[T any](v *T) *T {
+	copied := *v
+	return &copied
+}
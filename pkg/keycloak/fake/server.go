@@ -0,0 +1,558 @@
+package fake
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/spiros-spiros/baton-keycloak/pkg/keycloak"
+)
+
+// tokenLifetime is the expires_in of the tokens the server issues.
+const tokenLifetime = 5 * time.Minute
+
+// Server serves a Keycloak realm over HTTP: the token endpoint and the admin
+// REST endpoints keycloak.Client calls, so the connector can be run end to
+// end. Admin requests need a bearer token issued for ClientID and
+// ClientSecret by the client credentials grant.
+type Server struct {
+	*httptest.Server
+	Keycloak     *Keycloak
+	ClientID     string
+	ClientSecret string
+
+	mu     sync.Mutex
+	tokens map[string]time.Time
+}
+
+// NewServer starts serving kc. Close the server when done.
+func NewServer(kc *Keycloak, clientID, clientSecret string) *Server {
+	s := &Server{
+		Keycloak:     kc,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		tokens:       make(map[string]time.Time),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /realms/{realm}/protocol/openid-connect/token", s.token)
+
+	admin := func(pattern string, handler http.HandlerFunc) {
+		mux.Handle(pattern, s.authorized(handler))
+	}
+
+	admin("GET /admin/realms/{realm}", s.getRealm)
+	admin("GET /admin/realms/{realm}/default-groups", s.getDefaultGroups)
+
+	admin("GET /admin/realms/{realm}/users", s.getUsers)
+	admin("GET /admin/realms/{realm}/users/{user}", s.getUser)
+	admin("PUT /admin/realms/{realm}/users/{user}", s.updateUser)
+	admin("GET /admin/realms/{realm}/users/{user}/groups", s.getUserGroups)
+	admin("PUT /admin/realms/{realm}/users/{user}/groups/{group}", s.addUserToGroup)
+	admin("DELETE /admin/realms/{realm}/users/{user}/groups/{group}", s.removeUserFromGroup)
+	admin("GET /admin/realms/{realm}/users/{user}/role-mappings", s.getUserRoleMappings)
+	admin("DELETE /admin/realms/{realm}/attack-detection/brute-force/users/{user}", s.clearBruteForceLockout)
+
+	admin("GET /admin/realms/{realm}/groups", paged(kc.GetGroups))
+	admin("GET /admin/realms/{realm}/groups/{group}", s.getGroup)
+	admin("GET /admin/realms/{realm}/groups/{group}/members", s.getGroupMembers)
+	admin("GET /admin/realms/{realm}/groups/{group}/children", s.getSubGroups)
+	admin("GET /admin/realms/{realm}/group-by-path/{path...}", s.getGroupByPath)
+	admin("GET /admin/realms/{realm}/groups/{group}/role-mappings", s.getGroupRoleMappings)
+	admin("GET /admin/realms/{realm}/groups/{group}/management/permissions", s.getGroupManagementPermissions)
+
+	admin("GET /admin/realms/{realm}/roles", paged(kc.GetRealmRoles))
+	admin("GET /admin/realms/{realm}/roles/{role}/users", s.getRealmRoleUsers)
+	admin("GET /admin/realms/{realm}/roles/{role}/groups", s.getRealmRoleGroups)
+	admin("GET /admin/realms/{realm}/roles-by-id/{role}/composites", s.getRoleComposites)
+
+	admin("GET /admin/realms/{realm}/clients", s.getClients)
+	admin("GET /admin/realms/{realm}/clients/{client}", s.getClient)
+	admin("GET /admin/realms/{realm}/clients/{client}/roles", s.getClientRoles)
+	admin("GET /admin/realms/{realm}/clients/{client}/roles/{role}/users", s.getClientRoleUsers)
+	admin("GET /admin/realms/{realm}/clients/{client}/roles/{role}/groups", s.getClientRoleGroups)
+	admin("GET /admin/realms/{realm}/clients/{client}/service-account-user", s.getClientServiceAccount)
+	admin("GET /admin/realms/{realm}/clients/{client}/management/permissions", s.getClientManagementPermissions)
+	admin("GET /admin/realms/{realm}/clients/{client}/authz/resource-server/resource", s.getAuthzResources)
+	admin("GET /admin/realms/{realm}/clients/{client}/authz/resource-server/scope", s.getAuthzScopes)
+	admin("GET /admin/realms/{realm}/clients/{client}/authz/resource-server/permission", s.getAuthzPermissions)
+	admin("GET /admin/realms/{realm}/clients/{client}/authz/resource-server/permission/{permission}/resources", s.getAuthzPermissionResources)
+	admin("GET /admin/realms/{realm}/clients/{client}/authz/resource-server/permission/{permission}/scopes", s.getAuthzPermissionScopes)
+	admin("GET /admin/realms/{realm}/clients/{client}/authz/resource-server/policy/{policy}/associatedPolicies", s.getAssociatedPolicies)
+
+	admin("GET /admin/realms/{realm}/client-scopes", s.getClientScopes)
+	admin("GET /admin/realms/{realm}/client-scopes/{scope}/scope-mappings", s.getClientScopeScopeMappings)
+	admin("GET /admin/realms/{realm}/default-default-client-scopes", s.getDefaultClientScopes)
+	admin("GET /admin/realms/{realm}/default-optional-client-scopes", s.getOptionalClientScopes)
+
+	admin("GET /admin/realms/{realm}/admin-events", s.getAdminEvents)
+	admin("GET /admin/realms/{realm}/events", s.getUserEvents)
+
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// token implements the client credentials grant, with the client
+// authenticating by form parameters or basic auth.
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if !s.realmMatches(w, r) {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "client_credentials" {
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != s.ClientID || clientSecret != s.ClientSecret {
+		writeError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	accessToken := hex.EncodeToString(raw[:])
+
+	s.mu.Lock()
+	s.tokens[accessToken] = time.Now().Add(tokenLifetime)
+	s.mu.Unlock()
+
+	writeJSON(w, &gocloak.JWT{
+		AccessToken: accessToken,
+		ExpiresIn:   int(tokenLifetime.Seconds()),
+		TokenType:   "Bearer",
+	})
+}
+
+// authorized rejects requests for another realm or without a live token.
+func (s *Server) authorized(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.realmMatches(w, r) {
+			return
+		}
+
+		accessToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		s.mu.Lock()
+		expiry, issued := s.tokens[accessToken]
+		s.mu.Unlock()
+		if !ok || !issued || time.Now().After(expiry) {
+			writeError(w, http.StatusUnauthorized, "HTTP 401 Unauthorized")
+			return
+		}
+
+		next(w, r)
+	})
+}
+
+func (s *Server) realmMatches(w http.ResponseWriter, r *http.Request) bool {
+	if r.PathValue("realm") != s.Keycloak.realm {
+		writeError(w, http.StatusNotFound, "Realm not found.")
+		return false
+	}
+	return true
+}
+
+func (s *Server) getRealm(w http.ResponseWriter, r *http.Request) {
+	respond(w)(s.Keycloak.GetRealm(r.Context()))
+}
+
+func (s *Server) getDefaultGroups(w http.ResponseWriter, r *http.Request) {
+	respond(w)(s.Keycloak.GetDefaultGroups(r.Context()))
+}
+
+func (s *Server) getUser(w http.ResponseWriter, r *http.Request) {
+	respond(w)(s.Keycloak.GetUser(r.Context(), r.PathValue("user")))
+}
+
+// updateUser only applies the enabled flag and the attributes, the fields the
+// connector changes.
+func (s *Server) updateUser(w http.ResponseWriter, r *http.Request) {
+	var user gocloak.User
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID := r.PathValue("user")
+	var err error
+	switch {
+	case user.Enabled == nil:
+		_, err = s.Keycloak.GetUser(r.Context(), userID)
+	case *user.Enabled:
+		err = s.Keycloak.EnableUser(r.Context(), userID)
+	default:
+		err = s.Keycloak.DisableUser(r.Context(), userID)
+	}
+	// With the declarative user profile, the attributes sent replace the
+	// user's, so leaving them out clears them.
+	if err == nil {
+		var attributes map[string][]string
+		if user.Attributes != nil {
+			attributes = *user.Attributes
+		}
+		err = s.Keycloak.replaceUserAttributes(userID, attributes)
+	}
+	respondEmpty(w, err)
+}
+
+func (s *Server) getUserGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := s.Keycloak.GetUserGroups(r.Context(), r.PathValue("user"))
+	if err != nil {
+		respondEmpty(w, err)
+		return
+	}
+	writeJSON(w, nonNil(briefWindow(r, groups)))
+}
+
+func (s *Server) addUserToGroup(w http.ResponseWriter, r *http.Request) {
+	respondEmpty(w, s.Keycloak.AddUserToGroup(r.Context(), r.PathValue("user"), r.PathValue("group")))
+}
+
+func (s *Server) removeUserFromGroup(w http.ResponseWriter, r *http.Request) {
+	respondEmpty(w, s.Keycloak.RemoveUserFromGroup(r.Context(), r.PathValue("user"), r.PathValue("group")))
+}
+
+func (s *Server) getUserRoleMappings(w http.ResponseWriter, r *http.Request) {
+	respond(w)(s.Keycloak.GetUserRoleMappings(r.Context(), r.PathValue("user")))
+}
+
+func (s *Server) clearBruteForceLockout(w http.ResponseWriter, r *http.Request) {
+	respondEmpty(w, s.Keycloak.ClearUserBruteForceLockout(r.Context(), r.PathValue("user")))
+}
+
+func (s *Server) getGroup(w http.ResponseWriter, r *http.Request) {
+	respond(w)(s.Keycloak.GetGroup(r.Context(), r.PathValue("group")))
+}
+
+func (s *Server) getGroupByPath(w http.ResponseWriter, r *http.Request) {
+	respond(w)(s.Keycloak.GetGroupByPath(r.Context(), "/"+r.PathValue("path")))
+}
+
+func (s *Server) getSubGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := s.Keycloak.GetSubGroups(r.Context(), r.PathValue("group"))
+	if err != nil {
+		respondEmpty(w, err)
+		return
+	}
+	writeJSON(w, nonNil(briefWindow(r, groups)))
+}
+
+func (s *Server) getGroupMembers(w http.ResponseWriter, r *http.Request) {
+	members, err := s.Keycloak.GetGroupMembers(r.Context(), r.PathValue("group"))
+	if err != nil {
+		respondEmpty(w, err)
+		return
+	}
+	writeJSON(w, nonNil(briefWindow(r, members)))
+}
+
+func (s *Server) getGroupRoleMappings(w http.ResponseWriter, r *http.Request) {
+	respond(w)(s.Keycloak.GetGroupRoleMappings(r.Context(), r.PathValue("group")))
+}
+
+func (s *Server) getGroupManagementPermissions(w http.ResponseWriter, r *http.Request) {
+	respond(w)(s.Keycloak.GetGroupManagementPermissions(r.Context(), r.PathValue("group")))
+}
+
+func (s *Server) getRealmRoleUsers(w http.ResponseWriter, r *http.Request) {
+	roleName := r.PathValue("role")
+	paged(func(ctx context.Context, first int) ([]*gocloak.User, string, error) {
+		return s.Keycloak.GetRealmRoleUsers(ctx, roleName, first)
+	})(w, r)
+}
+
+func (s *Server) getRealmRoleGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := s.Keycloak.GetRealmRoleGroups(r.Context(), r.PathValue("role"))
+	if err != nil {
+		respondEmpty(w, err)
+		return
+	}
+	writeJSON(w, nonNil(briefWindow(r, groups)))
+}
+
+func (s *Server) getRoleComposites(w http.ResponseWriter, r *http.Request) {
+	respond(w)(s.Keycloak.GetRoleComposites(r.Context(), r.PathValue("role")))
+}
+
+// getClients supports the clientId filter keycloak.Client looks up the
+// realm-management client with.
+func (s *Server) getClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := collect(r.Context(), s.Keycloak.GetClients)
+	if err != nil {
+		respondEmpty(w, err)
+		return
+	}
+
+	if clientID := r.URL.Query().Get("clientId"); clientID != "" {
+		var matching []*gocloak.Client
+		for _, client := range clients {
+			if gocloak.PString(client.ClientID) == clientID {
+				matching = append(matching, client)
+			}
+		}
+		clients = matching
+	}
+
+	first, max := firstMax(r)
+	writeJSON(w, nonNil(window(clients, first, max)))
+}
+
+func (s *Server) getClient(w http.ResponseWriter, r *http.Request) {
+	respond(w)(s.Keycloak.GetClient(r.Context(), r.PathValue("client")))
+}
+
+func (s *Server) getClientRoles(w http.ResponseWriter, r *http.Request) {
+	idOfClient := r.PathValue("client")
+	paged(func(ctx context.Context, first int) ([]*gocloak.Role, string, error) {
+		return s.Keycloak.GetClientRoles(ctx, idOfClient, first)
+	})(w, r)
+}
+
+func (s *Server) getClientRoleUsers(w http.ResponseWriter, r *http.Request) {
+	idOfClient, roleName := r.PathValue("client"), r.PathValue("role")
+	paged(func(ctx context.Context, first int) ([]*gocloak.User, string, error) {
+		return s.Keycloak.GetClientRoleUsers(ctx, idOfClient, roleName, first)
+	})(w, r)
+}
+
+func (s *Server) getClientRoleGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := s.Keycloak.GetClientRoleGroups(r.Context(), r.PathValue("client"), r.PathValue("role"))
+	if err != nil {
+		respondEmpty(w, err)
+		return
+	}
+	writeJSON(w, nonNil(briefWindow(r, groups)))
+}
+
+func (s *Server) getClientServiceAccount(w http.ResponseWriter, r *http.Request) {
+	respond(w)(s.Keycloak.GetClientServiceAccount(r.Context(), r.PathValue("client")))
+}
+
+func (s *Server) getClientManagementPermissions(w http.ResponseWriter, r *http.Request) {
+	respond(w)(s.Keycloak.GetClientManagementPermissions(r.Context(), r.PathValue("client")))
+}
+
+// getUsers serves the user listing, filtered by the search and the
+// space-separated key:value attribute pairs of q.
+func (s *Server) getUsers(w http.ResponseWriter, r *http.Request) {
+	query := keycloak.UserQuery{Search: r.URL.Query().Get("search")}
+	for _, pair := range strings.Fields(r.URL.Query().Get("q")) {
+		key, value, _ := strings.Cut(pair, ":")
+		if query.Attributes == nil {
+			query.Attributes = make(map[string]string)
+		}
+		query.Attributes[key] = value
+	}
+
+	paged(func(ctx context.Context, first int) ([]*gocloak.User, string, error) {
+		return s.Keycloak.GetUsers(ctx, query, first)
+	})(w, r)
+}
+
+func (s *Server) getAdminEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	first, max := firstMax(r)
+	dateFrom, err := parseDate(query.Get("dateFrom"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respond(w)(s.Keycloak.GetAdminEvents(r.Context(), keycloak.GetAdminEventsParams{
+		DateFrom:      dateFrom,
+		ResourceTypes: query["resourceTypes"],
+		First:         first,
+		Max:           max,
+	}))
+}
+
+func (s *Server) getUserEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	first, max := firstMax(r)
+	dateFrom, err := parseDate(query.Get("dateFrom"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respond(w)(s.Keycloak.GetUserEvents(r.Context(), query["type"], dateFrom, first, max))
+}
+
+func (s *Server) getAuthzResources(w http.ResponseWriter, r *http.Request) {
+	idOfClient := r.PathValue("client")
+	paged(func(ctx context.Context, first int) ([]*gocloak.ResourceRepresentation, string, error) {
+		return s.Keycloak.GetAuthzResources(ctx, idOfClient, first)
+	})(w, r)
+}
+
+func (s *Server) getAuthzScopes(w http.ResponseWriter, r *http.Request) {
+	idOfClient := r.PathValue("client")
+	paged(func(ctx context.Context, first int) ([]*gocloak.ScopeRepresentation, string, error) {
+		return s.Keycloak.GetAuthzScopes(ctx, idOfClient, first)
+	})(w, r)
+}
+
+func (s *Server) getAuthzPermissions(w http.ResponseWriter, r *http.Request) {
+	idOfClient := r.PathValue("client")
+	paged(func(ctx context.Context, first int) ([]*gocloak.PermissionRepresentation, string, error) {
+		return s.Keycloak.GetAuthzPermissions(ctx, idOfClient, first)
+	})(w, r)
+}
+
+func (s *Server) getAuthzPermissionResources(w http.ResponseWriter, r *http.Request) {
+	respond(w)(s.Keycloak.GetAuthzPermissionResources(r.Context(), r.PathValue("client"), r.PathValue("permission")))
+}
+
+func (s *Server) getAuthzPermissionScopes(w http.ResponseWriter, r *http.Request) {
+	respond(w)(s.Keycloak.GetAuthzPermissionScopes(r.Context(), r.PathValue("client"), r.PathValue("permission")))
+}
+
+func (s *Server) getAssociatedPolicies(w http.ResponseWriter, r *http.Request) {
+	respond(w)(s.Keycloak.GetAssociatedPolicies(r.Context(), r.PathValue("client"), r.PathValue("policy")))
+}
+
+func (s *Server) getClientScopes(w http.ResponseWriter, r *http.Request) {
+	respond(w)(s.Keycloak.GetClientScopes(r.Context()))
+}
+
+func (s *Server) getClientScopeScopeMappings(w http.ResponseWriter, r *http.Request) {
+	respond(w)(s.Keycloak.GetClientScopeScopeMappings(r.Context(), r.PathValue("scope")))
+}
+
+func (s *Server) getDefaultClientScopes(w http.ResponseWriter, r *http.Request) {
+	defaultScopes, _, err := s.Keycloak.GetRealmDefaultClientScopes(r.Context())
+	respond(w)(defaultScopes, err)
+}
+
+func (s *Server) getOptionalClientScopes(w http.ResponseWriter, r *http.Request) {
+	_, optionalScopes, err := s.Keycloak.GetRealmDefaultClientScopes(r.Context())
+	respond(w)(optionalScopes, err)
+}
+
+// paged serves a listing of the fake with Keycloak's first and max query
+// parameters, which need not match the fake's PageSize.
+func paged[T any](list func(ctx context.Context, first int) ([]T, string, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		items, err := collect(r.Context(), list)
+		if err != nil {
+			respondEmpty(w, err)
+			return
+		}
+
+		first, max := firstMax(r)
+		writeJSON(w, nonNil(window(items, first, max)))
+	}
+}
+
+// collect follows the fake's pagination tokens to the end of a listing.
+func collect[T any](ctx context.Context, list func(ctx context.Context, first int) ([]T, string, error)) ([]T, error) {
+	var (
+		items []T
+		first int
+	)
+	for {
+		page, nextToken, err := list(ctx, first)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, page...)
+
+		if nextToken == "" {
+			return items, nil
+		}
+		if first, err = strconv.Atoi(nextToken); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// briefDefaultMax is how many results Keycloak returns from listings such as
+// group members when the request has no max.
+const briefDefaultMax = 100
+
+// briefWindow pages a listing Keycloak caps at briefDefaultMax by default.
+func briefWindow[T any](r *http.Request, items []T) []T {
+	first, max := firstMax(r)
+	if r.URL.Query().Get("max") == "" {
+		max = briefDefaultMax
+	}
+	return window(items, first, max)
+}
+
+func firstMax(r *http.Request) (int, int) {
+	query := r.URL.Query()
+	first, _ := strconv.Atoi(query.Get("first"))
+	max, _ := strconv.Atoi(query.Get("max"))
+	return first, max
+}
+
+// parseDate reads the day-granular dateFrom of the event endpoints.
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
+
+// respond writes the result of a fake call as JSON, or its error.
+func respond(w http.ResponseWriter) func(any, error) {
+	return func(v any, err error) {
+		if err != nil {
+			respondEmpty(w, err)
+			return
+		}
+		writeJSON(w, v)
+	}
+}
+
+// respondEmpty answers 204 No Content, or the status of the fake's error.
+func respondEmpty(w http.ResponseWriter, err error) {
+	var apiErr *gocloak.APIError
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.As(err, &apiErr) && apiErr.Code != 0:
+		writeError(w, apiErr.Code, apiErr.Message)
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError answers with Keycloak's error body.
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// nonNil keeps empty listings encoded as [] rather than null.
+func nonNil[T any](items []T) []T {
+	if items == nil {
+		return []T{}
+	}
+	return items
+}
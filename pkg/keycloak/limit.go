@@ -0,0 +1,97 @@
+package keycloak
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// WaitObserver is told how long a request waited for the client-side limits
+// before it was sent.
+type WaitObserver func(ctx context.Context, wait time.Duration)
+
+// limitTransport holds every request of a Client to a requests-per-second
+// rate and a number of requests in flight, so all builders share one budget.
+// A request counts as in flight until its response body is closed.
+type limitTransport struct {
+	base     http.RoundTripper
+	limiter  *rate.Limiter
+	inFlight chan struct{}
+	observe  WaitObserver
+}
+
+// newLimitTransport returns base unchanged when neither limit is set.
+// Non-positive values leave that limit off.
+func newLimitTransport(base http.RoundTripper, requestsPerSecond float64, maxInFlight int, observe WaitObserver) http.RoundTripper {
+	if requestsPerSecond <= 0 && maxInFlight <= 0 {
+		return base
+	}
+
+	t := &limitTransport{base: base, observe: observe}
+	if requestsPerSecond > 0 {
+		t.limiter = rate.NewLimiter(rate.Limit(requestsPerSecond), int(math.Ceil(requestsPerSecond)))
+	}
+	if maxInFlight > 0 {
+		t.inFlight = make(chan struct{}, maxInFlight)
+	}
+
+	return t
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	start := time.Now()
+
+	if t.inFlight != nil {
+		select {
+		case t.inFlight <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	if t.limiter != nil {
+		if err := t.limiter.Wait(ctx); err != nil {
+			t.release()
+			return nil, err
+		}
+	}
+
+	if t.observe != nil {
+		t.observe(ctx, time.Since(start))
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		t.release()
+		return nil, err
+	}
+
+	resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: t.release}
+	return resp, nil
+}
+
+func (t *limitTransport) release() {
+	if t.inFlight != nil {
+		<-t.inFlight
+	}
+}
+
+// releaseOnClose frees the in-flight slot of a request once its response has
+// been read.
+type releaseOnClose struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (r *releaseOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
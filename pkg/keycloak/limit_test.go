@@ -0,0 +1,163 @@
+package keycloak
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// limitedRoundTrip sends a request through transport, giving up after wait.
+func limitedRoundTrip(transport http.RoundTripper, url string, wait time.Duration) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return transport.RoundTrip(req)
+}
+
+func TestLimitTransportReleasesSlotOnClose(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "{}")
+	}))
+	defer srv.Close()
+	transport := newLimitTransport(http.DefaultTransport, 0, 1, nil)
+
+	held, err := limitedRoundTrip(transport, srv.URL, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := limitedRoundTrip(transport, srv.URL, 50*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("request sent while the only slot was held: %v", err)
+	}
+
+	held.Body.Close()
+	resp, err := limitedRoundTrip(transport, srv.URL, time.Second)
+	if err != nil {
+		t.Fatalf("slot not released when the response body was closed: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestLimitTransportReleasesSlotOnError(t *testing.T) {
+	refused := errors.New("connection refused")
+	var sent atomic.Int32
+	transport := newLimitTransport(roundTripFunc(func(*http.Request) (*http.Response, error) {
+		sent.Add(1)
+		return nil, refused
+	}), 0, 1, nil)
+
+	for range 3 {
+		if _, err := limitedRoundTrip(transport, "http://keycloak.invalid", time.Second); !errors.Is(err, refused) {
+			t.Fatalf("got %v, want the transport error", err)
+		}
+	}
+	if n := sent.Load(); n != 3 {
+		t.Errorf("sent %d requests, want 3", n)
+	}
+}
+
+func TestLimitTransportReleasesSlotOnRateLimitTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	// One request per second, so the second one can't be sent in time.
+	transport := newLimitTransport(http.DefaultTransport, 1, 2, nil)
+
+	held, err := limitedRoundTrip(transport, srv.URL, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Body.Close()
+	if _, err := limitedRoundTrip(transport, srv.URL, 10*time.Millisecond); err == nil {
+		t.Fatal("request sent faster than the rate limit")
+	}
+
+	resp, err := limitedRoundTrip(transport, srv.URL, 3*time.Second)
+	if err != nil {
+		t.Fatalf("slot not released by the request that timed out: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestClientSharesLimitAcrossCalls(t *testing.T) {
+	const maxInFlight = 2
+	var inFlight, peak atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(5 * time.Millisecond)
+
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/protocol/openid-connect/token"):
+			fmt.Fprint(w, `{"access_token":"token","expires_in":300}`)
+		case strings.HasSuffix(r.URL.Path, "/missing"):
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"not found"}`)
+		default:
+			fmt.Fprint(w, `{"id":"id"}`)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(Config{
+		ServerURL:           srv.URL,
+		Realm:               "test",
+		ClientID:            "baton",
+		ClientSecret:        "secret",
+		MaxInFlightRequests: maxInFlight,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	// Calls of different builders, some of them failing.
+	for i := range 30 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var err error
+			switch i % 3 {
+			case 0:
+				_, err = c.GetUser(ctx, "id")
+			case 1:
+				_, err = c.GetGroup(ctx, "id")
+			default:
+				if _, err := c.GetUser(ctx, "missing"); err == nil {
+					t.Error("got a user Keycloak doesn't have")
+				}
+			}
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		t.Fatal("calls stuck waiting for an in-flight slot")
+	}
+	if n := peak.Load(); n > maxInFlight {
+		t.Errorf("%d requests in flight at once, want at most %d", n, maxInFlight)
+	}
+}
@@ -0,0 +1,81 @@
+package keycloak
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// RealmManagementClientID is the clientId of the client holding the realm's
+// admin roles and the fine-grained admin permissions.
+const RealmManagementClientID = "realm-management"
+
+// GetGroupManagementPermissions returns the fine-grained admin permissions of a
+// group. They are reported as disabled when the realm does not support them.
+func (c *Client) GetGroupManagementPermissions(ctx context.Context, groupID string) (*gocloak.ManagementPermissionRepresentation, error) {
+	token, err := c.tokens.TokenContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token: %w", err)
+	}
+
+	permissions, err := c.client.GetGroupManagementPermissions(ctx, token.AccessToken, c.realm, groupID)
+	if isNotImplemented(err) {
+		return &gocloak.ManagementPermissionRepresentation{Enabled: pointer(false)}, nil
+	}
+	return permissions, err
+}
+
+// GetClientManagementPermissions returns the fine-grained admin permissions of a
+// client. They are reported as disabled when the realm does not support them.
+func (c *Client) GetClientManagementPermissions(ctx context.Context, idOfClient string) (*gocloak.ManagementPermissionRepresentation, error) {
+	token, err := c.tokens.TokenContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token: %w", err)
+	}
+
+	permissions, err := c.client.GetClientManagementPermissions(ctx, token.AccessToken, c.realm, idOfClient)
+	if isNotImplemented(err) {
+		return &gocloak.ManagementPermissionRepresentation{Enabled: pointer(false)}, nil
+	}
+	return permissions, err
+}
+
+// RealmManagementClient looks up the internal ID of the realm-management client,
+// whose resource server holds the fine-grained admin permissions, once and
+// remembers it.
+func (c *Client) RealmManagementClient(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.realmManagementID != "" {
+		return c.realmManagementID, nil
+	}
+
+	token, err := c.tokens.TokenContext(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get token: %w", err)
+	}
+
+	clients, err := c.client.GetClients(ctx, token.AccessToken, c.realm, gocloak.GetClientsParams{
+		ClientID: pointer(RealmManagementClientID),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get %s client: %w", RealmManagementClientID, err)
+	}
+	if len(clients) == 0 || clients[0].ID == nil {
+		return "", fmt.Errorf("%s client not found", RealmManagementClientID)
+	}
+
+	c.realmManagementID = *clients[0].ID
+	return c.realmManagementID, nil
+}
+
+// isNotImplemented reports whether Keycloak rejected the call because the
+// admin-fine-grained-authz feature is turned off.
+func isNotImplemented(err error) bool {
+	var apiErr *gocloak.APIError
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotImplemented
+}
@@ -0,0 +1,29 @@
+package keycloak
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// GetRealm returns the representation of the configured realm, including its
+// event settings.
+func (c *Client) GetRealm(ctx context.Context) (*gocloak.RealmRepresentation, error) {
+	token, err := c.tokens.TokenContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token: %w", err)
+	}
+
+	return c.client.GetRealm(ctx, token.AccessToken, c.realm)
+}
+
+// GetDefaultGroups returns the groups every new user of the realm joins.
+func (c *Client) GetDefaultGroups(ctx context.Context) ([]*gocloak.Group, error) {
+	token, err := c.tokens.TokenContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token: %w", err)
+	}
+
+	return c.client.GetDefaultGroups(ctx, token.AccessToken, c.realm)
+}
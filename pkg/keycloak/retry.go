@@ -0,0 +1,185 @@
+package keycloak
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+const (
+	// retryMaxAttempts bounds how often a read is sent before its last
+	// response is returned as is.
+	retryMaxAttempts = 4
+	retryBaseDelay   = 500 * time.Millisecond
+	// retryMaxDelay caps the backoff and any Retry-After Keycloak or the
+	// ingress in front of it asks for. Longer waits are left to the SDK.
+	retryMaxDelay = 30 * time.Second
+)
+
+// retryTransport resends idempotent reads that failed on the network or were
+// answered with 429 or a 502/503/504 from a proxy, with exponential backoff and
+// full jitter, or after the Retry-After the server asked for. Writes are never
+// resent, a lost response does not tell whether they were applied.
+type retryTransport struct {
+	base http.RoundTripper
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isIdempotentRead(req) {
+		resp, err := t.base.RoundTrip(req)
+		if err == nil {
+			observeRateLimit(req, resp)
+		}
+		return resp, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if attempt == retryMaxAttempts || !shouldRetry(req, resp, err) {
+			if err == nil {
+				observeRateLimit(req, resp)
+			}
+			return resp, err
+		}
+
+		delay := backoff(attempt)
+		if resp != nil {
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+				if retryAfter > retryMaxDelay {
+					observeRateLimit(req, resp)
+					return resp, nil
+				}
+				delay = retryAfter
+			}
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+func isIdempotentRead(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return req.Body == nil || req.Body == http.NoBody
+	default:
+		return false
+	}
+}
+
+func shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		return req.Context().Err() == nil && !errors.Is(err, context.Canceled)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// backoff is a random delay up to retryBaseDelay doubled for every attempt made.
+func backoff(attempt int) time.Duration {
+	ceiling := retryBaseDelay << (attempt - 1)
+	if ceiling <= 0 || ceiling > retryMaxDelay {
+		ceiling = retryMaxDelay
+	}
+	return rand.N(ceiling) + 1
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an
+// HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+
+	delay := time.Until(at)
+	if delay < 0 {
+		delay = 0
+	}
+	return delay, true
+}
+
+// rateLimitStatus remembers the latest response to the requests of one call,
+// which carries whatever rate limit headers the server or its ingress sends.
+type rateLimitStatus struct {
+	mu         sync.Mutex
+	statusCode int
+	header     http.Header
+}
+
+type rateLimitStatusKey struct{}
+
+// WithRateLimitStatus returns a context whose requests record their latest
+// response, for RateLimitStatus to report. Each call scopes its own state, so
+// concurrent calls don't see each other's responses.
+func WithRateLimitStatus(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rateLimitStatusKey{}, &rateLimitStatus{})
+}
+
+// RateLimitStatus returns the status code and headers of the latest response
+// to a request made with ctx, for reporting rate limits to the caller. It
+// returns zero values when ctx came without WithRateLimitStatus or no
+// request was made.
+func RateLimitStatus(ctx context.Context) (int, http.Header) {
+	s, ok := ctx.Value(rateLimitStatusKey{}).(*rateLimitStatus)
+	if !ok {
+		return 0, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.statusCode, s.header.Clone()
+}
+
+// observeRateLimit records a final response in the state of its request's
+// context, if any.
+func observeRateLimit(req *http.Request, resp *http.Response) {
+	s, ok := req.Context().Value(rateLimitStatusKey{}).(*rateLimitStatus)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statusCode = resp.StatusCode
+	s.header = resp.Header.Clone()
+}
+
+// IsRateLimited reports whether err is Keycloak, or its ingress, still
+// refusing the request for load after every retry.
+func IsRateLimited(err error) bool {
+	var apiErr *gocloak.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	return apiErr.Code == http.StatusTooManyRequests || apiErr.Code == http.StatusServiceUnavailable
+}
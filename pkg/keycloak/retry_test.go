@@ -0,0 +1,167 @@
+package keycloak
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// refusingServer answers the first refusals requests with status and
+// retryAfter, and every later one with 200.
+func refusingServer(t *testing.T, refusals int32, status int, retryAfter func() string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) > refusals {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if retryAfter != nil {
+			w.Header().Set("Retry-After", retryAfter())
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func roundTrip(t *testing.T, transport http.RoundTripper, req *http.Request) *http.Response {
+	t.Helper()
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestRetryAfter(t *testing.T) {
+	for name, retryAfter := range map[string]func() string{
+		"seconds": func() string { return "1" },
+		// HTTP dates are to the second, so this is between one and two
+		// seconds away.
+		"HTTP date": func() string { return time.Now().Add(2 * time.Second).UTC().Format(http.TimeFormat) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv, requests := refusingServer(t, 1, http.StatusTooManyRequests, retryAfter)
+			req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			start := time.Now()
+			resp := roundTrip(t, &retryTransport{base: http.DefaultTransport}, req)
+
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("status %d, want 200 after the retry", resp.StatusCode)
+			}
+			if n := requests.Load(); n != 2 {
+				t.Errorf("sent %d requests, want 2", n)
+			}
+			// The backoff alone never waits this long on a first retry.
+			if elapsed := time.Since(start); elapsed < time.Second-50*time.Millisecond {
+				t.Errorf("retried after %v, want the second Retry-After asked for", elapsed)
+			}
+		})
+	}
+}
+
+func TestRetryAfterBeyondMaxDelay(t *testing.T) {
+	srv, requests := refusingServer(t, 1, http.StatusServiceUnavailable, func() string {
+		return "120"
+	})
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	resp := roundTrip(t, &retryTransport{base: http.DefaultTransport}, req)
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want the 503 returned as is", resp.StatusCode)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("sent %d requests, want 1", n)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("gave up after %v, want right away", elapsed)
+	}
+}
+
+func TestRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	srv, requests := refusingServer(t, retryMaxAttempts, http.StatusBadGateway, func() string { return "0" })
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := roundTrip(t, &retryTransport{base: http.DefaultTransport}, req)
+
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("status %d, want the last 502", resp.StatusCode)
+	}
+	if n := requests.Load(); n != retryMaxAttempts {
+		t.Errorf("sent %d requests, want %d", n, retryMaxAttempts)
+	}
+}
+
+func TestRetryNeverResendsWrites(t *testing.T) {
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodGet} {
+		t.Run(method, func(t *testing.T) {
+			srv, requests := refusingServer(t, 1, http.StatusServiceUnavailable, func() string { return "0" })
+			// A GET with a body is not a plain read either.
+			req, err := http.NewRequest(method, srv.URL, strings.NewReader("{}"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp := roundTrip(t, &retryTransport{base: http.DefaultTransport}, req)
+
+			if resp.StatusCode != http.StatusServiceUnavailable {
+				t.Errorf("status %d, want the 503 returned as is", resp.StatusCode)
+			}
+			if n := requests.Load(); n != 1 {
+				t.Errorf("sent %d requests, want 1", n)
+			}
+		})
+	}
+}
+
+func TestRateLimitStatusIsScopedToContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Ratelimit-Remaining", r.URL.Query().Get("remaining"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	transport := &retryTransport{base: http.DefaultTransport}
+
+	send := func(ctx context.Context, remaining string) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"?remaining="+remaining, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		roundTrip(t, transport, req)
+	}
+	first := WithRateLimitStatus(context.Background())
+	second := WithRateLimitStatus(context.Background())
+	send(first, "10")
+	send(second, "20")
+	send(context.Background(), "30")
+
+	for ctx, want := range map[context.Context]string{first: "10", second: "20"} {
+		statusCode, header := RateLimitStatus(ctx)
+		if statusCode != http.StatusOK || header.Get("X-Ratelimit-Remaining") != want {
+			t.Errorf("rate limit status %d with %q remaining, want 200 with %s", statusCode, header.Get("X-Ratelimit-Remaining"), want)
+		}
+	}
+	if statusCode, _ := RateLimitStatus(context.Background()); statusCode != 0 {
+		t.Errorf("context without rate limit status reports %d", statusCode)
+	}
+}
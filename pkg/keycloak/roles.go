@@ -0,0 +1,145 @@
+package keycloak
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+func (c *Client) GetRealmRoles(ctx context.Context, first int) ([]*gocloak.Role, string, error) {
+	token, err := c.tokens.TokenContext(ctx)
+	if err != nil {
+		return nil, strconv.Itoa(first), fmt.Errorf("failed to get token: %w", err)
+	}
+
+	max := 300
+
+	roles, err := c.client.GetRealmRoles(ctx, token.AccessToken, c.realm, gocloak.GetRoleParams{
+		First: pointer(first),
+		Max:   pointer(max),
+	})
+	if err != nil {
+		return nil, strconv.Itoa(first), fmt.Errorf("failed to get realm roles: %w", err)
+	}
+
+	if len(roles) == 0 {
+		return nil, "", nil
+	}
+
+	return roles, strconv.Itoa(first + max), nil
+}
+
+// GetRealmRoleUsers returns the users the realm role is directly mapped to.
+func (c *Client) GetRealmRoleUsers(ctx context.Context, roleName string, first int) ([]*gocloak.User, string, error) {
+	token, err := c.tokens.TokenContext(ctx)
+	if err != nil {
+		return nil, strconv.Itoa(first), fmt.Errorf("failed to get token: %w", err)
+	}
+
+	max := 300
+
+	users, err := c.client.GetUsersByRoleName(ctx, token.AccessToken, c.realm, roleName, gocloak.GetUsersByRoleParams{
+		First: pointer(first),
+		Max:   pointer(max),
+	})
+	if err != nil {
+		return nil, strconv.Itoa(first), fmt.Errorf("failed to get realm role users: %w", err)
+	}
+
+	if len(users) == 0 {
+		return nil, "", nil
+	}
+
+	return users, strconv.Itoa(first + max), nil
+}
+
+// GetClientRoleUsers returns the users the client role is directly mapped to.
+func (c *Client) GetClientRoleUsers(ctx context.Context, idOfClient, roleName string, first int) ([]*gocloak.User, string, error) {
+	token, err := c.tokens.TokenContext(ctx)
+	if err != nil {
+		return nil, strconv.Itoa(first), fmt.Errorf("failed to get token: %w", err)
+	}
+
+	max := 300
+
+	users, err := c.client.GetUsersByClientRoleName(ctx, token.AccessToken, c.realm, idOfClient, roleName, gocloak.GetUsersByRoleParams{
+		First: pointer(first),
+		Max:   pointer(max),
+	})
+	if err != nil {
+		return nil, strconv.Itoa(first), fmt.Errorf("failed to get client role users: %w", err)
+	}
+
+	if len(users) == 0 {
+		return nil, "", nil
+	}
+
+	return users, strconv.Itoa(first + max), nil
+}
+
+// GetRealmRoleGroups returns the groups the realm role is directly mapped to.
+func (c *Client) GetRealmRoleGroups(ctx context.Context, roleName string) ([]*gocloak.Group, error) {
+	return c.getRoleGroups(ctx, "failed to get realm role groups", "roles", url.PathEscape(roleName), "groups")
+}
+
+// GetClientRoleGroups returns the groups the client role is directly mapped to.
+func (c *Client) GetClientRoleGroups(ctx context.Context, idOfClient, roleName string) ([]*gocloak.Group, error) {
+	return c.getRoleGroups(ctx, "failed to get client role groups", "clients", idOfClient, "roles", url.PathEscape(roleName), "groups")
+}
+
+// getRoleGroups reads every page of a role's groups. gocloak's GetGroupsByRole
+// sends no first or max, so Keycloak would only return the first 100.
+func (c *Client) getRoleGroups(ctx context.Context, msg string, path ...string) ([]*gocloak.Group, error) {
+	return readAll(func(first, max int) ([]*gocloak.Group, error) {
+		token, err := c.tokens.TokenContext(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get token: %w", err)
+		}
+
+		var groups []*gocloak.Group
+		resp, err := c.client.GetRequestWithBearerAuth(ctx, token.AccessToken).
+			SetQueryParams(map[string]string{
+				"first": strconv.Itoa(first),
+				"max":   strconv.Itoa(max),
+			}).
+			SetResult(&groups).
+			Get(c.adminURL(path...))
+		if err := checkResponse(resp, err, msg); err != nil {
+			return nil, err
+		}
+		return groups, nil
+	})
+}
+
+// GetRoleComposites returns the realm and client roles a composite role contains.
+func (c *Client) GetRoleComposites(ctx context.Context, roleID string) ([]*gocloak.Role, error) {
+	token, err := c.tokens.TokenContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token: %w", err)
+	}
+
+	return c.client.GetCompositeRolesByRoleID(ctx, token.AccessToken, c.realm, roleID)
+}
+
+// GetUserRoleMappings returns the realm and client roles mapped directly to a user.
+func (c *Client) GetUserRoleMappings(ctx context.Context, userID string) (*gocloak.MappingsRepresentation, error) {
+	token, err := c.tokens.TokenContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token: %w", err)
+	}
+
+	return c.client.GetRoleMappingByUserID(ctx, token.AccessToken, c.realm, userID)
+}
+
+// GetGroupRoleMappings returns the realm and client roles mapped directly to a group.
+func (c *Client) GetGroupRoleMappings(ctx context.Context, groupID string) (*gocloak.MappingsRepresentation, error) {
+	token, err := c.tokens.TokenContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token: %w", err)
+	}
+
+	return c.client.GetRoleMappingByGroupID(ctx, token.AccessToken, c.realm, groupID)
+}
@@ -0,0 +1,189 @@
+package keycloak
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	"golang.org/x/oauth2"
+)
+
+// tokenRefreshMargin is how long before expiry a token is replaced, so requests
+// in flight never carry a token that expires on the way.
+const tokenRefreshMargin = 30 * time.Second
+
+// loginFunc obtains a new token from Keycloak.
+type loginFunc func(ctx context.Context) (*gocloak.JWT, error)
+
+// TokenSource hands out the admin API token shared by every request of a
+// Client. The token is cached and replaced shortly before it expires.
+// Concurrent callers needing a new token wait for a single login, and callers
+// holding a still valid token keep using it while the replacement is fetched.
+type TokenSource struct {
+	login loginFunc
+
+	mu    sync.Mutex
+	token *oauth2.Token
+	// refreshing is closed when the login in flight finishes, nil when idle.
+	refreshing chan struct{}
+	refreshErr error
+}
+
+var _ oauth2.TokenSource = (*TokenSource)(nil)
+
+func newTokenSource(login loginFunc) *TokenSource {
+	return &TokenSource{login: login}
+}
+
+// Token implements oauth2.TokenSource.
+func (s *TokenSource) Token() (*oauth2.Token, error) {
+	return s.TokenContext(context.Background())
+}
+
+// TokenContext returns a valid token, logging in again when the cached one is
+// about to expire.
+func (s *TokenSource) TokenContext(ctx context.Context) (*oauth2.Token, error) {
+	s.mu.Lock()
+	for {
+		if s.token != nil && time.Until(s.token.Expiry) > tokenRefreshMargin {
+			token := s.token
+			s.mu.Unlock()
+			return token, nil
+		}
+
+		if s.refreshing == nil {
+			break
+		}
+
+		// Someone else is already logging in. The old token is fine until it
+		// actually expires, otherwise wait for the new one.
+		if s.token.Valid() {
+			token := s.token
+			s.mu.Unlock()
+			return token, nil
+		}
+
+		refreshing := s.refreshing
+		s.mu.Unlock()
+		select {
+		case <-refreshing:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		s.mu.Lock()
+
+		// Only give up when the shared login failed for a reason of its own,
+		// not because its caller went away.
+		if s.refreshErr != nil && !s.token.Valid() &&
+			!errors.Is(s.refreshErr, context.Canceled) && !errors.Is(s.refreshErr, context.DeadlineExceeded) {
+			err := s.refreshErr
+			s.mu.Unlock()
+			return nil, err
+		}
+	}
+
+	refreshing := make(chan struct{})
+	s.refreshing = refreshing
+	s.mu.Unlock()
+
+	token, err := s.fetch(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshing = nil
+	s.refreshErr = err
+	close(refreshing)
+
+	if err != nil {
+		// A failed early refresh is retried by the next caller, the old token
+		// still works meanwhile.
+		if s.token.Valid() {
+			return s.token, nil
+		}
+		return nil, err
+	}
+
+	s.token = token
+	return token, nil
+}
+
+// invalidate drops the cached token if it is still the one Keycloak rejected,
+// so the next caller logs in again.
+func (s *TokenSource) invalidate(accessToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != nil && s.token.AccessToken == accessToken {
+		s.token = nil
+	}
+}
+
+func (s *TokenSource) fetch(ctx context.Context) (*oauth2.Token, error) {
+	jwt, err := s.login(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oauth2.Token{
+		AccessToken:  jwt.AccessToken,
+		TokenType:    "Bearer",
+		RefreshToken: jwt.RefreshToken,
+		Expiry:       time.Now().Add(time.Duration(jwt.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// reauthTransport retries a request once with a new token when Keycloak
+// rejects the token it carried, e.g. after the session was revoked or the
+// server restarted.
+type reauthTransport struct {
+	base   http.RoundTripper
+	tokens *TokenSource
+}
+
+func (t *reauthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// Token requests carry no bearer token and are never retried.
+	stale, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return resp, nil
+	}
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	// Buffer the rejected response so it no longer holds an in-flight slot
+	// the login below may need.
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	t.tokens.invalidate(stale)
+	token, err := t.tokens.TokenContext(req.Context())
+	if err != nil {
+		return resp, nil
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		retry.Body, err = req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+	}
+	retry.Header.Set("Authorization", "Bearer "+token.AccessToken)
+
+	return t.base.RoundTrip(retry)
+}
@@ -0,0 +1,199 @@
+package keycloak
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+func TestTokenSourceSharesOneLogin(t *testing.T) {
+	var logins atomic.Int32
+	release := make(chan struct{})
+	tokens := newTokenSource(func(ctx context.Context) (*gocloak.JWT, error) {
+		n := logins.Add(1)
+		<-release
+		return &gocloak.JWT{AccessToken: fmt.Sprintf("token-%d", n), ExpiresIn: 300}, nil
+	})
+
+	const callers = 10
+	got := make([]string, callers)
+	var wg sync.WaitGroup
+	for i := range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := tokens.TokenContext(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			got[i] = token.AccessToken
+		}()
+	}
+	// Give every caller the time to find the login in flight.
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := logins.Load(); n != 1 {
+		t.Errorf("%d concurrent callers logged in %d times, want once", callers, n)
+	}
+	for i, token := range got {
+		if token != "token-1" {
+			t.Errorf("caller %d got %q, want token-1", i, token)
+		}
+	}
+}
+
+func TestTokenSourceRefreshesEarly(t *testing.T) {
+	var logins atomic.Int32
+	tokens := newTokenSource(func(ctx context.Context) (*gocloak.JWT, error) {
+		n := logins.Add(1)
+		// Valid for less than tokenRefreshMargin.
+		return &gocloak.JWT{AccessToken: fmt.Sprintf("token-%d", n), ExpiresIn: 10}, nil
+	})
+
+	for range 2 {
+		if _, err := tokens.TokenContext(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := logins.Load(); n != 2 {
+		t.Errorf("logged in %d times for a token about to expire, want a login per call", n)
+	}
+}
+
+func TestTokenSourceCancelledCallerDoesNotPoisonRefresh(t *testing.T) {
+	var logins atomic.Int32
+	started := make(chan struct{})
+	tokens := newTokenSource(func(ctx context.Context) (*gocloak.JWT, error) {
+		if logins.Add(1) == 1 {
+			close(started)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return &gocloak.JWT{AccessToken: "token", ExpiresIn: 300}, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := tokens.TokenContext(ctx)
+		first <- err
+	}()
+	<-started
+
+	second := make(chan error, 1)
+	go func() {
+		token, err := tokens.TokenContext(context.Background())
+		if err == nil && token.AccessToken != "token" {
+			err = fmt.Errorf("got %q, want token", token.AccessToken)
+		}
+		second <- err
+	}()
+	// Let the second caller wait for the login of the first.
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	if err := <-first; err == nil {
+		t.Error("cancelled caller got a token")
+	}
+	if err := <-second; err != nil {
+		t.Errorf("caller waiting on a cancelled login: %v", err)
+	}
+	if n := logins.Load(); n != 2 {
+		t.Errorf("logged in %d times, want the waiting caller to log in again", n)
+	}
+}
+
+func TestReauthTransport(t *testing.T) {
+	for name, tc := range map[string]struct {
+		// accepted is the token the server takes, any other one gets a 401.
+		accepted     string
+		wantStatus   int
+		wantRequests int32
+	}{
+		"retried with a new token": {accepted: "token-2", wantStatus: http.StatusOK, wantRequests: 2},
+		"rejected again":           {accepted: "none", wantStatus: http.StatusUnauthorized, wantRequests: 2},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				body, _ := io.ReadAll(r.Body)
+				if string(body) != "payload" {
+					t.Errorf("request body %q, want payload", body)
+				}
+				if r.Header.Get("Authorization") != "Bearer "+tc.accepted {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer srv.Close()
+
+			var logins atomic.Int32
+			tokens := newTokenSource(func(ctx context.Context) (*gocloak.JWT, error) {
+				return &gocloak.JWT{AccessToken: fmt.Sprintf("token-%d", logins.Add(1)), ExpiresIn: 300}, nil
+			})
+			token, err := tokens.TokenContext(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, err := http.NewRequest(http.MethodPut, srv.URL, strings.NewReader("payload"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+			resp, err := (&reauthTransport{base: http.DefaultTransport, tokens: tokens}).RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tc.wantStatus {
+				t.Errorf("status %d, want %d", resp.StatusCode, tc.wantStatus)
+			}
+			if n := requests.Load(); n != tc.wantRequests {
+				t.Errorf("sent %d requests, want %d", n, tc.wantRequests)
+			}
+		})
+	}
+}
+
+func TestReauthTransportLeavesTokenRequests(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	tokens := newTokenSource(func(ctx context.Context) (*gocloak.JWT, error) {
+		t.Error("logged in for a request without a bearer token")
+		return nil, context.Canceled
+	})
+	req, err := http.NewRequest(http.MethodPost, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (&reauthTransport{base: http.DefaultTransport, tokens: tokens}).RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if n := requests.Load(); n != 1 {
+		t.Errorf("sent %d requests, want the token request only once", n)
+	}
+}
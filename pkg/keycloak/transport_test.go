@@ -0,0 +1,98 @@
+package keycloak
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBaseTransportTLS(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	srv.StartTLS()
+	defer srv.Close()
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caPath, ca, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range map[string]struct {
+		cfg     Config
+		wantErr bool
+	}{
+		"trusted CA":           {cfg: Config{CACertPath: caPath}},
+		"system CAs only":      {cfg: Config{}, wantErr: true},
+		"insecure skip verify": {cfg: Config{InsecureSkipVerify: true}},
+		"TLS 1.3 required":     {cfg: Config{CACertPath: caPath, MinTLSVersion: "1.3"}, wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			transport, err := newBaseTransport(tc.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer transport.CloseIdleConnections()
+
+			resp, err := (&http.Client{Transport: transport}).Get(srv.URL)
+			if tc.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Error("connected to the server")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+		})
+	}
+}
+
+func TestBaseTransportProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+
+	transport, err := newBaseTransport(Config{ProxyURL: proxy.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (&http.Client{Transport: transport}).Get("http://keycloak.invalid/realms/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if proxied != "http://keycloak.invalid/realms/test" {
+		t.Errorf("proxy got %q, want the request to Keycloak", proxied)
+	}
+}
+
+func TestBaseTransportRejectsConfig(t *testing.T) {
+	notPEM := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, cfg := range map[string]Config{
+		"CA bundle without certificates": {CACertPath: notPEM},
+		"missing CA bundle":              {CACertPath: filepath.Join(t.TempDir(), "missing.pem")},
+		"TLS 1.1":                        {MinTLSVersion: "1.1"},
+		"client key without certificate": {ClientKeyPath: notPEM},
+		"invalid proxy URL":              {ProxyURL: "http://proxy:port"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := newBaseTransport(cfg); err == nil {
+				t.Error("newBaseTransport accepted the configuration")
+			}
+		})
+	}
+}